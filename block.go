@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"strconv"
 
 	cid "github.com/ipfs/go-cid"
 	node "github.com/ipfs/go-ipld-format"
@@ -14,22 +16,38 @@ import (
 type Block struct {
 	rawdata []byte
 
-	Version      uint32   `json:"version"`
-	Parent       *cid.Cid `json:"parent"`
-	MerkleRoot   *cid.Cid `json:"tx"`
-	Timestamp    uint32   `json:"timestamp"`
-	Difficulty   uint32   `json:"difficulty"`
-	Nonce        []byte   `json:"nonce"`
-	Solution     []byte   `json:"solution"`
-	ReservedHash []byte   `json:"reserved"`
-
-	cid *cid.Cid
+	Version      uint32  `json:"version"`
+	Parent       cid.Cid `json:"parent"`
+	MerkleRoot   cid.Cid `json:"tx"`
+	Timestamp    uint32  `json:"timestamp"`
+	Difficulty   uint32  `json:"difficulty"`
+	Nonce        []byte  `json:"nonce"`
+	Solution     []byte  `json:"solution"`
+	ReservedHash []byte  `json:"reserved"`
+
+	// Height is not part of the header. It is filled in from the coinbase
+	// when decoding or fetching a full block, and is zero when unknown. Set
+	// it on header-only blocks to interpret ReservedHash.
+	Height uint32 `json:"height,omitempty"`
+
+	// TxCount is not part of the header either. It is filled in when
+	// decoding or fetching a full block, and is zero when unknown. It is
+	// needed to resolve txs/<index> paths on the block itself, since the
+	// TxTree nodes don't know their depth; a Resolver works it out from the
+	// TxTree when it is unknown.
+	TxCount uint32 `json:"txCount,omitempty"`
+
+	// Params selects the network used to interpret height dependent fields.
+	// MainNetParams is assumed when it is nil.
+	Params *Params `json:"-"`
+
+	cid cid.Cid
 }
 
 // assert that Block matches the Node interface for ipld
 var _ node.Node = (*Block)(nil)
 
-func (b *Block) Cid() *cid.Cid {
+func (b *Block) Cid() cid.Cid {
 	h, _ := mh.Sum(b.header(), mh.DBL_SHA2_256, -1)
 	return cid.NewCidV1(cid.ZcashBlock, h)
 }
@@ -45,7 +63,7 @@ func (b *Block) Links() []*node.Link {
 			Cid:  b.MerkleRoot,
 		},
 	}
-	if b.Parent != nil {
+	if b.Parent.Defined() {
 		txs = append(txs, &node.Link{
 			Name: "parent",
 			Cid:  b.Parent,
@@ -62,6 +80,13 @@ func (b *Block) Loggable() map[string]interface{} {
 }
 
 // Resolve attempts to traverse a path through this block.
+//
+// The height and the commitment fields (finalSaplingRoot, lightClientRoot
+// and blockCommitments) need the block's height, and txs paths its
+// transaction count. Neither is part of the header, so on a block decoded
+// from its header alone, such as by DecodeNode, they fail with
+// ErrUnknownHeight and ErrUnknownTxCount. Blocks from FetchBlock or a block
+// message have both, and a Resolver works them out as needed.
 func (b *Block) Resolve(path []string) (interface{}, []string, error) {
 	if len(path) == 0 {
 		return nil, nil, fmt.Errorf("zero length path")
@@ -83,11 +108,121 @@ func (b *Block) Resolve(path []string) (interface{}, []string, error) {
 		return b.Solution, path[1:], nil
 	case "reserved":
 		return b.ReservedHash, path[1:], nil
+	case "height":
+		height, ok := b.height()
+		if !ok {
+			return nil, nil, ErrUnknownHeight
+		}
+		return height, path[1:], nil
+	case "txCount":
+		if b.TxCount == 0 {
+			return nil, nil, ErrUnknownTxCount
+		}
+		return b.TxCount, path[1:], nil
+	case "txs":
+		if len(path) < 2 {
+			return nil, nil, fmt.Errorf("txs path needs a transaction index")
+		}
+
+		treePath, err := b.txPath(path[1])
+		if err != nil {
+			return nil, nil, err
+		}
+		return &node.Link{Cid: b.MerkleRoot}, append(treePath, path[2:]...), nil
+	case FinalSaplingRootField, LightClientRootField, BlockCommitmentsField:
+		name, val, err := b.Commitment(nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if name != path[0] {
+			return nil, nil, fmt.Errorf("block at height %d has no %s, reserved hash is %s", b.Height, path[0], name)
+		}
+		return val, path[1:], nil
 	default:
 		return nil, nil, fmt.Errorf("no such link")
 	}
 }
 
+// txPath returns the path from the merkle root through the TxTree nodes to
+// the transaction at the given index. The leaves of the tree are in block
+// order, so the path spells out the index in binary.
+func (b *Block) txPath(s string) ([]string, error) {
+	if b.TxCount == 0 {
+		return nil, ErrUnknownTxCount
+	}
+
+	index, err := parseIndex(s, int(b.TxCount))
+	if err != nil {
+		return nil, err
+	}
+
+	depth := treeDepth(b.TxCount)
+	path := make([]string, depth)
+	for i := range path {
+		path[depth-1-i] = strconv.Itoa(index >> uint(i) & 1)
+	}
+	return path, nil
+}
+
+// treeDepth returns the number of TxTree layers above n transactions.
+func treeDepth(n uint32) int {
+	depth := 0
+	for uint64(1)<<uint(depth) < uint64(n) {
+		depth++
+	}
+	return depth
+}
+
+func (b *Block) params() *Params {
+	if b.Params == nil {
+		return MainNetParams
+	}
+	return b.Params
+}
+
+// ErrUnknownHeight is returned when interpreting the reserved hash of a block
+// whose height is not known, such as one decoded from its header alone.
+var ErrUnknownHeight = errors.New("block height is unknown")
+
+// ErrUnknownTxCount is returned when resolving a txs path on a block whose
+// transaction count is not known, such as one decoded from its header alone.
+var ErrUnknownTxCount = errors.New("transaction count of block is unknown")
+
+// Commitment interprets the header's reserved hash for the block's height on
+// the given network, or on the block's own network if params is nil. It
+// returns the name of the commitment (one of ReservedField,
+// FinalSaplingRootField, LightClientRootField or BlockCommitmentsField) along
+// with its value. The commitments are roots of trees that have no IPLD
+// representation, so they are returned as raw bytes.
+//
+// It returns ErrUnknownHeight if the block's Height is not known.
+func (b *Block) Commitment(params *Params) (string, []byte, error) {
+	if params == nil {
+		params = b.params()
+	}
+
+	height, ok := b.height()
+	if !ok {
+		return "", nil, ErrUnknownHeight
+	}
+	return params.reservedField(height), b.ReservedHash, nil
+}
+
+// height returns the block's height and whether it is known. Only the
+// genesis block is known to be at height zero.
+func (b *Block) height() (uint32, bool) {
+	if b.isGenesis() {
+		return 0, true
+	}
+	return b.Height, b.Height != 0
+}
+
+// isGenesis reports whether the block has no parent. The genesis header
+// links to an all zero parent hash.
+func (b *Block) isGenesis() bool {
+	return !b.Parent.Defined() || isBlank(cidToHash(b.Parent))
+}
+
 // ResolveLink is a helper function that allows easier traversal of links through blocks
 func (b *Block) ResolveLink(path []string) (*node.Link, []string, error) {
 	out, rest, err := b.Resolve(path)
@@ -103,18 +238,29 @@ func (b *Block) ResolveLink(path []string) (*node.Link, []string, error) {
 	return lnk, rest, nil
 }
 
-func cidToHash(c *cid.Cid) []byte {
+func cidToHash(c cid.Cid) []byte {
 	h := []byte(c.Hash())
 	return h[len(h)-32:]
 }
 
-func hashToCid(hv []byte, t uint64) *cid.Cid {
+func hashToCid(hv []byte, t uint64) cid.Cid {
 	h, _ := mh.Encode(hv, mh.DBL_SHA2_256)
 	return cid.NewCidV1(t, h)
 }
 
 // header generates a serialized block header for this block
 func (b *Block) header() []byte {
+	buf := bytes.NewBuffer(b.powHeader())
+
+	writeVarInt(buf, uint64(len(b.Solution)))
+	buf.Write(b.Solution)
+
+	return buf.Bytes()
+}
+
+// powHeader serializes the header up to and including the nonce. This is the
+// input to the Equihash instance the solution solves.
+func (b *Block) powHeader() []byte {
 	buf := new(bytes.Buffer)
 
 	i := make([]byte, 4)
@@ -133,9 +279,6 @@ func (b *Block) header() []byte {
 
 	buf.Write(b.Nonce)
 
-	writeVarInt(buf, uint64(len(b.Solution)))
-	buf.Write(b.Solution)
-
 	return buf.Bytes()
 }
 
@@ -153,7 +296,17 @@ func (b *Block) String() string {
 
 func (b *Block) Tree(p string, depth int) []string {
 	// TODO: this isnt a correct implementation yet
-	return []string{"difficulty", "nonce", "version", "timestamp", "tx", "parent", "solution", "reserved"}
+	out := []string{"difficulty", "nonce", "version", "timestamp", "tx", "parent", "solution", "reserved"}
+	if _, ok := b.height(); ok {
+		out = append(out, "height")
+	}
+	if name, _, err := b.Commitment(nil); err == nil && name != ReservedField {
+		out = append(out, name)
+	}
+	if b.TxCount != 0 {
+		out = append(out, "txCount")
+	}
+	return out
 }
 
 func (b *Block) ZecSha() []byte {
@@ -0,0 +1,139 @@
+package ipldzec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Just enough CBOR to read and write the small dag-cbor nodes this package
+// produces, without pulling in a full cbor library.
+
+const (
+	cborUint  = 0
+	cborBytes = 2
+	cborText  = 3
+	cborArray = 4
+	cborMap   = 5
+	cborTag   = 6
+
+	// cborCidTag marks a byte string as a CID link in dag-cbor
+	cborCidTag = 42
+)
+
+func cborHead(w io.Writer, major byte, n uint64) {
+	m := major << 5
+	switch {
+	case n < 24:
+		w.Write([]byte{m | byte(n)})
+	case n <= 0xff:
+		w.Write([]byte{m | 24, byte(n)})
+	case n <= 0xffff:
+		buf := []byte{m | 25, 0, 0}
+		binary.BigEndian.PutUint16(buf[1:], uint16(n))
+		w.Write(buf)
+	case n <= 0xffffffff:
+		buf := []byte{m | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(buf[1:], uint32(n))
+		w.Write(buf)
+	default:
+		buf := make([]byte, 9)
+		buf[0] = m | 27
+		binary.BigEndian.PutUint64(buf[1:], n)
+		w.Write(buf)
+	}
+}
+
+func cborWriteText(w io.Writer, s string) {
+	cborHead(w, cborText, uint64(len(s)))
+	io.WriteString(w, s)
+}
+
+type cborReader struct {
+	r *bytes.Reader
+}
+
+// head reads an item header of the given major type, returning its argument.
+// Arguments must be in their shortest form, as dag-cbor requires, so that a
+// node has a single encoding.
+func (c *cborReader) head(major byte) (uint64, error) {
+	b, err := c.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	if b>>5 != major {
+		return 0, fmt.Errorf("expected cbor major type %d, got %d", major, b>>5)
+	}
+
+	info := b & 0x1f
+	var size int
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, fmt.Errorf("unsupported cbor item")
+	}
+
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(c.r, buf[8-size:]); err != nil {
+		return 0, err
+	}
+
+	// the argument must not fit in a shorter head
+	v := binary.BigEndian.Uint64(buf)
+	if size == 1 && v < 24 || size > 1 && v>>(uint(size)*4) == 0 {
+		return 0, fmt.Errorf("non-minimal cbor item")
+	}
+	return v, nil
+}
+
+func (c *cborReader) expect(major byte, n uint64) error {
+	v, err := c.head(major)
+	if err != nil {
+		return err
+	}
+	if v != n {
+		return fmt.Errorf("unexpected cbor value %d", v)
+	}
+	return nil
+}
+
+func (c *cborReader) bytes() ([]byte, error) {
+	n, err := c.head(cborBytes)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(c.r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return readBuf(c.r, int(n))
+}
+
+func (c *cborReader) expectText(s string) error {
+	n, err := c.head(cborText)
+	if err != nil {
+		return err
+	}
+	if n != uint64(len(s)) {
+		return fmt.Errorf("expected key %q", s)
+	}
+
+	buf, err := readBuf(c.r, int(n))
+	if err != nil {
+		return err
+	}
+	if string(buf) != s {
+		return fmt.Errorf("expected key %q, got %q", s, buf)
+	}
+	return nil
+}
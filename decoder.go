@@ -0,0 +1,359 @@
+package ipldzec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Errors wrapped by a DecodeError, saying what is wrong with the input.
+var (
+	ErrTruncated          = errors.New("unexpected end of data")
+	ErrOversize           = errors.New("size exceeds limit")
+	ErrNonCanonicalVarint = errors.New("non-canonical compact size")
+
+	// ErrTrailingData and ErrNonCanonical are only returned by Strict
+	// decoding, except that DecodeMerkleBlock always rejects trailing data.
+	ErrTrailingData = errors.New("trailing data")
+	ErrNonCanonical = errors.New("encoding does not round trip")
+)
+
+// DecodeError is returned for malformed input. Offset is the position of the
+// offending field from the start of the input, and Err is one of the errors
+// above, an error describing an invalid value, or an error from the
+// underlying reader.
+type DecodeError struct {
+	Offset int64
+	Field  string
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decoding %s at offset %d: %s", e.Field, e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Decoder reads block messages from a stream: each block header, followed by
+// its transactions one at a time, without holding the whole block in memory.
+// Consecutive blocks can be read from the same stream, as found in blk*.dat
+// files, and the offset of every element is reported for indexing.
+type Decoder struct {
+	r    *reader
+	opts DecodeOptions
+
+	blk  *Block
+	nTx  int
+	read int
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return NewDecoderWithOptions(r, DecodeOptions{})
+}
+
+// NewDecoderWithOptions returns a Decoder reading from r. The Decoder never
+// reads past the element it is decoding, so readers without a ReadByte
+// method are read a byte at a time where needed; wrap them in a bufio.Reader
+// if that is slow.
+func NewDecoderWithOptions(r io.Reader, opts DecodeOptions) *Decoder {
+	return &Decoder{
+		r:    newReader(r),
+		opts: opts,
+	}
+}
+
+// Offset returns the number of bytes consumed from the stream so far.
+func (d *Decoder) Offset() int64 {
+	return d.r.n
+}
+
+// ReadFrame reads the network magic and block size that precede each block
+// in a blk*.dat file. Call it before ReadHeader when reading such files.
+func (d *Decoder) ReadFrame() (uint32, uint32, error) {
+	if err := d.finished(); err != nil {
+		return 0, 0, err
+	}
+
+	off := d.r.n
+	d.r.limit = math.MaxInt64
+	buf, err := d.r.buf("frame", 8)
+	if err != nil {
+		return 0, 0, atEOF(err, off)
+	}
+
+	return binary.BigEndian.Uint32(buf[:4]), binary.LittleEndian.Uint32(buf[4:]), nil
+}
+
+// ReadHeader reads the next block header along with its transaction count,
+// returning the header and the offset it starts at. It returns io.EOF if the
+// stream ends cleanly before a new block, and a *DecodeError if the data is
+// malformed or the block exceeds the size limit. The header's Height is
+// filled in once the coinbase transaction has been read.
+func (d *Decoder) ReadHeader() (*Block, int64, error) {
+	if err := d.finished(); err != nil {
+		return nil, 0, err
+	}
+
+	off := d.r.n
+	d.r.limit = math.MaxInt64
+	d.r.limitTo(int64(d.opts.maxBlockSize()))
+
+	d.r.record(d.opts.Strict)
+	blk, err := readBlock(d.r)
+	if err != nil {
+		return nil, 0, atEOF(err, off)
+	}
+
+	if err := d.r.checkRecorded(off, "header", blk.RawData()); err != nil {
+		return nil, 0, err
+	}
+
+	nTx, err := d.r.count("txCount", minTxSize)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	blk.Params = d.opts.params()
+	blk.TxCount = uint32(nTx)
+	d.blk, d.nTx, d.read = blk, nTx, 0
+	return blk, off, nil
+}
+
+// TxCount returns the number of transactions in the current block.
+func (d *Decoder) TxCount() int {
+	return d.nTx
+}
+
+// ReadTx reads the next transaction of the current block, returning it and
+// the offset it starts at. It returns io.EOF once every transaction of the
+// block has been read.
+func (d *Decoder) ReadTx() (*Tx, int64, error) {
+	if d.blk == nil {
+		return nil, 0, fmt.Errorf("block header has not been read")
+	}
+
+	if d.read >= d.nTx {
+		return nil, 0, io.EOF
+	}
+
+	off := d.r.n
+	prev := d.r.limitTo(int64(d.opts.maxTxSizeAt(d.blk.Height)))
+	d.r.record(d.opts.Strict)
+	tx, err := readTx(d.r)
+	d.r.limit = prev
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := d.r.checkRecorded(off, "tx", tx.RawData()); err != nil {
+		return nil, 0, err
+	}
+
+	tx.Params = d.opts.params()
+	if d.read == 0 && !d.blk.isGenesis() {
+		d.blk.Height = coinbaseHeight(tx)
+
+		// the coinbase gives the height, so its own size is checked late
+		if d.r.n-off > int64(d.opts.maxTxSizeAt(d.blk.Height)) {
+			return nil, 0, d.r.fail(off, "tx", ErrOversize)
+		}
+	}
+
+	d.read++
+	return tx, off, nil
+}
+
+// atEOF turns a truncation at off, before anything was read, into io.EOF.
+func atEOF(err error, off int64) error {
+	if de, ok := err.(*DecodeError); ok && de.Offset == off && de.Err == ErrTruncated {
+		return io.EOF
+	}
+	return err
+}
+
+func (d *Decoder) finished() error {
+	if d.read < d.nTx {
+		return fmt.Errorf("%d transactions of the current block have not been read", d.nTx-d.read)
+	}
+	return nil
+}
+
+// byteReader is what readVarint needs from its input: the marker byte is
+// read on its own, so that nothing past the varint is consumed.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func asByteReader(r io.Reader) byteReader {
+	if br, ok := r.(byteReader); ok {
+		return br
+	}
+	return &singleByteReader{r: r}
+}
+
+type singleByteReader struct {
+	r   io.Reader
+	buf [1]byte
+}
+
+func (s *singleByteReader) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+func (s *singleByteReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(s.r, s.buf[:]); err != nil {
+		return 0, err
+	}
+	return s.buf[0], nil
+}
+
+// reader is the input of the decoding functions. It tracks the offset into
+// the input for error reporting, and the offset the element being decoded
+// must end by, so that no length read from the input can make us allocate or
+// loop beyond the size limits.
+type reader struct {
+	r     byteReader
+	n     int64
+	limit int64
+
+	recording bool
+	rec       []byte
+}
+
+func newReader(r io.Reader) *reader {
+	return &reader{
+		r:     asByteReader(r),
+		limit: math.MaxInt64,
+	}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.recording {
+		r.rec = append(r.rec, p[:n]...)
+	}
+	return n, err
+}
+
+func (r *reader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.n++
+		if r.recording {
+			r.rec = append(r.rec, b)
+		}
+	}
+	return b, err
+}
+
+// record starts keeping a copy of the bytes read if on is set, for
+// checkRecorded to compare against the re-encoded element.
+func (r *reader) record(on bool) {
+	r.recording = on
+	r.rec = r.rec[:0]
+}
+
+// checkRecorded implements Strict decoding: the element starting at off,
+// read since record was called, must re-encode to exactly the bytes read.
+func (r *reader) checkRecorded(off int64, field string, raw []byte) error {
+	if !r.recording {
+		return nil
+	}
+	r.recording = false
+
+	if bytes.Equal(r.rec, raw) {
+		return nil
+	}
+
+	i := 0
+	for i < len(raw) && i < len(r.rec) && raw[i] == r.rec[i] {
+		i++
+	}
+	return &DecodeError{Offset: off + int64(i), Field: field, Err: ErrNonCanonical}
+}
+
+// checkEnd implements Strict decoding for byte slices: all of the size bytes
+// must have been consumed.
+func (r *reader) checkEnd(strict bool, size int, field string) error {
+	if strict && r.n != int64(size) {
+		return &DecodeError{Offset: r.n, Field: field, Err: ErrTrailingData}
+	}
+	return nil
+}
+
+// limitTo restricts reads to the next size bytes, unless the current limit
+// is tighter, returning the previous limit.
+func (r *reader) limitTo(size int64) int64 {
+	prev := r.limit
+	if size < r.limit-r.n {
+		r.limit = r.n + size
+	}
+	return prev
+}
+
+func (r *reader) fail(off int64, field string, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrTruncated
+	}
+	return &DecodeError{Offset: off, Field: field, Err: err}
+}
+
+func (r *reader) buf(field string, size int) ([]byte, error) {
+	off := r.n
+	if int64(size) > r.limit-off {
+		return nil, r.fail(off, field, ErrOversize)
+	}
+
+	out := make([]byte, size)
+	if _, err := io.ReadFull(r, out); err != nil {
+		return nil, r.fail(off, field, err)
+	}
+	return out, nil
+}
+
+func (r *reader) uint32(field string) (uint32, error) {
+	buf, err := r.buf(field, 4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(buf), nil
+}
+
+func (r *reader) uint64(field string) (uint64, error) {
+	buf, err := r.buf(field, 8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+// count reads the length of a vector whose elements are at least elemSize
+// bytes each, rejecting lengths that can't fit within the limit.
+func (r *reader) count(field string, elemSize int) (int, error) {
+	off := r.n
+	n, err := readVarint(r)
+	if err != nil {
+		return 0, r.fail(off, field, err)
+	}
+
+	if r.n > r.limit || n > uint64(r.limit-r.n)/uint64(elemSize) {
+		return 0, r.fail(off, field, ErrOversize)
+	}
+	return int(n), nil
+}
+
+// bytes reads a byte string prefixed by its length.
+func (r *reader) bytes(field string) ([]byte, error) {
+	n, err := r.count(field, 1)
+	if err != nil {
+		return nil, err
+	}
+	return r.buf(field, n)
+}
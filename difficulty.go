@@ -0,0 +1,146 @@
+package ipldzec
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+
+	cid "github.com/ipfs/go-cid"
+	node "github.com/ipfs/go-ipld-format"
+)
+
+// Consensus constants of the DigiShield v3 style difficulty adjustment.
+const (
+	PowAveragingWindow = 17
+	PowMaxAdjustDown   = 32 // percent
+	PowMaxAdjustUp     = 16 // percent
+	MedianTimeSpan     = 11
+
+	PreBlossomPowTargetSpacing  = 150
+	PostBlossomPowTargetSpacing = 75
+
+	// DifficultyWindow is the number of ancestors needed to compute the
+	// difficulty of a block: the averaging window, plus the blocks needed
+	// for the median time past of the block preceding it.
+	DifficultyWindow = PowAveragingWindow + MedianTimeSpan
+)
+
+// PowTargetSpacing returns the target block interval in seconds at the given
+// height.
+func (p *Params) PowTargetSpacing(height uint32) int64 {
+	if height >= p.BlossomHeight {
+		return PostBlossomPowTargetSpacing
+	}
+	return PreBlossomPowTargetSpacing
+}
+
+// ExpectedDifficulty returns the nBits required of a block at the given height
+// with the given timestamp. Ancestors must be ordered newest first, starting
+// with the block's parent, and hold at least DifficultyWindow blocks unless
+// the chain is shorter than that.
+func ExpectedDifficulty(params *Params, height, timestamp uint32, ancestors []*Block) uint32 {
+	powLimit := BigToCompact(params.PowLimit)
+	if height == 0 || len(ancestors) == 0 {
+		return powLimit
+	}
+
+	last := ancestors[0]
+
+	// testnet allows a minimum difficulty block once six block intervals
+	// have passed without one
+	if params.MinDifficultyBlocksAfterHeight != 0 && height-1 >= params.MinDifficultyBlocksAfterHeight {
+		if int64(timestamp) > int64(last.Timestamp)+params.PowTargetSpacing(height)*6 {
+			return powLimit
+		}
+	}
+
+	if len(ancestors) <= PowAveragingWindow {
+		return powLimit
+	}
+
+	total := new(big.Int)
+	for _, blk := range ancestors[:PowAveragingWindow] {
+		total.Add(total, CompactToBig(blk.Difficulty))
+	}
+	avg := total.Div(total, big.NewInt(PowAveragingWindow))
+
+	lastMTP := medianTimePast(ancestors)
+	firstMTP := medianTimePast(ancestors[PowAveragingWindow:])
+
+	return calculateNextWorkRequired(params, avg, lastMTP, firstMTP, height)
+}
+
+func calculateNextWorkRequired(params *Params, avg *big.Int, lastTime, firstTime int64, height uint32) uint32 {
+	windowTimespan := PowAveragingWindow * params.PowTargetSpacing(height)
+	minTimespan := windowTimespan * (100 - PowMaxAdjustUp) / 100
+	maxTimespan := windowTimespan * (100 + PowMaxAdjustDown) / 100
+
+	// dampen the adjustment, using medians to prevent time warp attacks
+	actual := lastTime - firstTime
+	actual = windowTimespan + (actual-windowTimespan)/4
+
+	if actual < minTimespan {
+		actual = minTimespan
+	}
+	if actual > maxTimespan {
+		actual = maxTimespan
+	}
+
+	next := new(big.Int).Div(avg, big.NewInt(windowTimespan))
+	next.Mul(next, big.NewInt(actual))
+
+	if next.Cmp(params.PowLimit) > 0 {
+		next.Set(params.PowLimit)
+	}
+
+	return BigToCompact(next)
+}
+
+// medianTimePast returns the median timestamp of the first MedianTimeSpan
+// blocks, or of all of them if there are fewer.
+func medianTimePast(blocks []*Block) int64 {
+	if len(blocks) > MedianTimeSpan {
+		blocks = blocks[:MedianTimeSpan]
+	}
+
+	times := make([]int64, len(blocks))
+	for i, blk := range blocks {
+		times[i] = int64(blk.Timestamp)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	return times[len(times)/2]
+}
+
+// CheckDifficulty checks that the block's Difficulty is the value the
+// consensus rules require at the given height. See ExpectedDifficulty for the
+// ancestors it needs.
+func (b *Block) CheckDifficulty(params *Params, height uint32, ancestors []*Block) error {
+	expected := ExpectedDifficulty(params, height, b.Timestamp, ancestors)
+	if b.Difficulty != expected {
+		return fmt.Errorf("block difficulty is %08x, expected %08x", b.Difficulty, expected)
+	}
+	return nil
+}
+
+// FetchAncestors follows parent links from the block c, returning up to n
+// blocks newest first, starting with c itself. It returns fewer blocks if it
+// reaches the genesis block.
+func FetchAncestors(ctx context.Context, ng node.NodeGetter, c cid.Cid, n int) ([]*Block, error) {
+	var out []*Block
+	for len(out) < n {
+		blk, err := getBlock(ctx, ng, c)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, blk)
+
+		if !blk.Parent.Defined() || isBlank(cidToHash(blk.Parent)) {
+			break
+		}
+		c = blk.Parent
+	}
+	return out, nil
+}
@@ -0,0 +1,137 @@
+package ipldzec
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Equihash parameters used by mainnet and testnet. Regtest uses n=48, k=5.
+const (
+	EquihashN = 200
+	EquihashK = 9
+)
+
+// VerifySolution checks that the block's Solution is a valid Equihash (n, k)
+// proof of work for its header. It does not check the header hash against the
+// difficulty target.
+func (b *Block) VerifySolution(n, k uint32) error {
+	if k == 0 || k >= 16 || n%8 != 0 || n > 512 || n%(k+1) != 0 || n/(k+1) >= 31 {
+		return fmt.Errorf("invalid equihash parameters (%d, %d)", n, k)
+	}
+
+	collisionBits := n / (k + 1)
+	indexBits := collisionBits + 1
+	nIndices := 1 << k
+
+	if len(b.Solution)*8 != nIndices*int(indexBits) {
+		return fmt.Errorf("equihash solution is %d bytes, expected %d", len(b.Solution), nIndices*int(indexBits)/8)
+	}
+
+	indices := make([]uint32, nIndices)
+	for i := range indices {
+		indices[i] = uint32(readBits(b.Solution, i*int(indexBits), int(indexBits)))
+	}
+
+	gen := newEquihashGenerator(n, k, b.powHeader())
+
+	type subtree struct {
+		hash    []byte
+		indices []uint32
+	}
+
+	layer := make([]subtree, nIndices)
+	for i, idx := range indices {
+		layer[i] = subtree{hash: gen.hash(idx), indices: []uint32{idx}}
+	}
+
+	for round := 0; len(layer) > 1; round++ {
+		next := make([]subtree, len(layer)/2)
+		for i := range next {
+			left, right := layer[2*i], layer[2*i+1]
+
+			x := make([]byte, len(left.hash))
+			for j := range x {
+				x[j] = left.hash[j] ^ right.hash[j]
+			}
+
+			if readBits(x, round*int(collisionBits), int(collisionBits)) != 0 {
+				return fmt.Errorf("invalid equihash solution: no collision in round %d", round)
+			}
+
+			if left.indices[0] >= right.indices[0] {
+				return fmt.Errorf("invalid equihash solution: index tree incorrectly ordered")
+			}
+
+			if !distinctIndices(left.indices, right.indices) {
+				return fmt.Errorf("invalid equihash solution: duplicate indices")
+			}
+
+			next[i] = subtree{
+				hash:    x,
+				indices: append(append([]uint32{}, left.indices...), right.indices...),
+			}
+		}
+		layer = next
+	}
+
+	if !isBlank(layer[0].hash) {
+		return fmt.Errorf("invalid equihash solution: hashes do not xor to zero")
+	}
+
+	return nil
+}
+
+// equihashGenerator produces the n bit hash for a given index. Each BLAKE2b
+// invocation yields 512/n hashes, so consecutive indices share an output.
+type equihashGenerator struct {
+	n, perOutput uint32
+	person       []byte
+	header       []byte
+}
+
+func newEquihashGenerator(n, k uint32, header []byte) *equihashGenerator {
+	person := make([]byte, 16)
+	copy(person, "ZcashPoW")
+	binary.LittleEndian.PutUint32(person[8:], n)
+	binary.LittleEndian.PutUint32(person[12:], k)
+
+	return &equihashGenerator{
+		n:         n,
+		perOutput: 512 / n,
+		person:    person,
+		header:    header,
+	}
+}
+
+func (g *equihashGenerator) hash(index uint32) []byte {
+	i := make([]byte, 4)
+	binary.LittleEndian.PutUint32(i, index/g.perOutput)
+
+	out := blake2bPersonal(int(g.perOutput*g.n/8), g.person, g.header, i)
+	start := (index % g.perOutput) * (g.n / 8)
+	return out[start : start+g.n/8]
+}
+
+// readBits reads count bits starting at bit offset off from b, most
+// significant bit first.
+func readBits(b []byte, off, count int) uint64 {
+	var v uint64
+	for i := off; i < off+count; i++ {
+		bit := (b[i/8] >> (7 - uint(i%8))) & 1
+		v = v<<1 | uint64(bit)
+	}
+	return v
+}
+
+func distinctIndices(a, b []uint32) bool {
+	seen := make(map[uint32]struct{}, len(a))
+	for _, v := range a {
+		seen[v] = struct{}{}
+	}
+	for _, v := range b {
+		if _, ok := seen[v]; ok {
+			return false
+		}
+	}
+	return true
+}
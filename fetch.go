@@ -0,0 +1,300 @@
+package ipldzec
+
+import (
+	"context"
+	"fmt"
+
+	cid "github.com/ipfs/go-cid"
+	node "github.com/ipfs/go-ipld-format"
+)
+
+// FetchBlock fetches the block c and its transactions through ng. See
+// FetchTransactions. The returned block has its Height and TxCount filled in
+// as when decoding a block message.
+func FetchBlock(ctx context.Context, ng node.NodeGetter, c cid.Cid) (*Block, []*Tx, error) {
+	blk, err := getBlock(ctx, ng, c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	txs, err := FetchTransactions(ctx, ng, blk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the getter may share its node, so fill in a copy
+	full := *blk
+	full.TxCount = uint32(len(txs))
+	if !full.isGenesis() {
+		full.Height = coinbaseHeight(txs[0])
+	}
+
+	return &full, txs, nil
+}
+
+// FetchBlockMessage fetches the block c and its transactions through ng and
+// serializes them as a block message.
+func FetchBlockMessage(ctx context.Context, ng node.NodeGetter, c cid.Cid) ([]byte, error) {
+	blk, txs, err := FetchBlock(ctx, ng, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return EncodeBlockMessage(blk, txs)
+}
+
+// FetchTransactions walks the TxTree of blk from its merkle root down to the
+// transactions, returning them in block order. The nodes of each layer of the
+// tree are requested together with GetMany, so a DAGService can fetch them
+// concurrently. The result is checked against the block's merkle root.
+//
+// If blk.TxCount is known it tells which layer holds the transactions, so
+// 64 byte transactions and TxTree nodes can't be mistaken for each other.
+func FetchTransactions(ctx context.Context, ng node.NodeGetter, blk *Block) ([]*Tx, error) {
+	opts := DecodeOptions{Params: blk.params()}
+
+	layer := []cid.Cid{blk.MerkleRoot}
+	for depth := 0; ; depth++ {
+		if blk.TxCount != 0 {
+			opts.TxNodeKind = TxTreeNode
+			if depth >= treeDepth(blk.TxCount) {
+				opts.TxNodeKind = TxNode
+			}
+		}
+
+		nds, err := getLayer(ctx, ng, layer, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		var next []cid.Cid
+		var txs []*Tx
+		for _, nd := range nds {
+			switch nd := nd.(type) {
+			case *TxTree:
+				next = append(next, nd.Left.Cid)
+
+				// the last node of a layer with an odd count is paired
+				// with itself
+				if !nd.Right.Cid.Equals(nd.Left.Cid) {
+					next = append(next, nd.Right.Cid)
+				}
+			case *Tx:
+				txs = append(txs, nd)
+			}
+		}
+
+		if len(txs) == 0 {
+			if len(next) > MaxBlockSize/minTxSize {
+				return nil, fmt.Errorf("transaction tree of block %s is too large", blk.Cid())
+			}
+
+			layer = next
+			continue
+		}
+
+		if len(next) > 0 {
+			return nil, fmt.Errorf("transaction tree of block %s is unbalanced", blk.Cid())
+		}
+
+		if err := blk.CheckMerkleRoot(txs); err != nil {
+			return nil, err
+		}
+
+		return txs, nil
+	}
+}
+
+// txTreeDepth returns the number of TxTree layers above the transactions of
+// blk. Without a TxCount it follows the left branch of the tree down to the
+// coinbase.
+func txTreeDepth(ctx context.Context, ng node.NodeGetter, blk *Block) (int, error) {
+	if blk.TxCount != 0 {
+		return treeDepth(blk.TxCount), nil
+	}
+
+	_, depth, err := fetchCoinbase(ctx, ng, blk)
+	return depth, err
+}
+
+// fetchCoinbase follows the left branch of blk's TxTree down to its first
+// transaction, returning it along with the number of TxTree layers above it.
+func fetchCoinbase(ctx context.Context, ng node.NodeGetter, blk *Block) (*Tx, int, error) {
+	opts := DecodeOptions{Params: blk.params()}
+	c := blk.MerkleRoot
+	for depth := 0; depth <= treeDepth(MaxBlockSize/minTxSize); depth++ {
+		nd, err := getOrLookup(ctx, ng, c, opts)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		nd, err = asTxNode(nd, opts)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		switch nd := nd.(type) {
+		case *Tx:
+			return nd, depth, nil
+		case *TxTree:
+			c = nd.Left.Cid
+		}
+	}
+
+	return nil, 0, fmt.Errorf("transaction tree of block %s is too deep", blk.Cid())
+}
+
+// blockHeight returns the height of blk, reading it from the coinbase if the
+// block doesn't record it.
+func blockHeight(ctx context.Context, ng node.NodeGetter, blk *Block) (uint32, error) {
+	if height, ok := blk.height(); ok {
+		return height, nil
+	}
+
+	coinbase, _, err := fetchCoinbase(ctx, ng, blk)
+	if err != nil {
+		return 0, err
+	}
+
+	height := coinbaseHeight(coinbase)
+	if height == 0 {
+		return 0, ErrUnknownHeight
+	}
+	return height, nil
+}
+
+// txCount returns the number of transactions in blk. If blk.TxCount is
+// unknown it is worked out from the TxTree: the left branch gives the depth
+// of the tree, and the right branch, where the last node of a layer with an
+// odd count is paired with itself, gives how many leaves it has.
+func txCount(ctx context.Context, ng node.NodeGetter, blk *Block) (uint32, error) {
+	if blk.TxCount != 0 {
+		return blk.TxCount, nil
+	}
+
+	depth, err := txTreeDepth(ctx, ng, blk)
+	if err != nil {
+		return 0, err
+	}
+
+	opts := DecodeOptions{Params: blk.params(), TxNodeKind: TxTreeNode}
+	count := uint32(1)
+	c := blk.MerkleRoot
+	for ; depth > 0; depth-- {
+		nds, err := getLayer(ctx, ng, []cid.Cid{c}, opts)
+		if err != nil {
+			return 0, err
+		}
+
+		tree, ok := nds[0].(*TxTree)
+		if !ok {
+			return 0, fmt.Errorf("transaction tree of block %s is unbalanced", blk.Cid())
+		}
+
+		// a right child that isn't a copy of the left has a full subtree
+		// to its left
+		if tree.Right.Cid.Equals(tree.Left.Cid) {
+			c = tree.Left.Cid
+		} else {
+			count += 1 << uint(depth-1)
+			c = tree.Right.Cid
+		}
+	}
+
+	return count, nil
+}
+
+// getLayer fetches the nodes cids with a single GetMany, returning them in
+// the same order as decoded Tx or TxTree nodes. Only the nodes the GetMany
+// didn't return are then fetched one at a time, looking them up by txid if
+// need be, see TxLookup.
+func getLayer(ctx context.Context, ng node.NodeGetter, cids []cid.Cid, opts DecodeOptions) ([]node.Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var unique []cid.Cid
+	got := make(map[string]node.Node)
+	for _, c := range cids {
+		if _, ok := got[c.KeyString()]; !ok {
+			got[c.KeyString()] = nil
+			unique = append(unique, c)
+		}
+	}
+
+	ch := ng.GetMany(ctx, unique)
+recv:
+	for remaining := len(unique); remaining > 0; remaining-- {
+		select {
+		case opt, ok := <-ch:
+			if !ok {
+				break recv
+			}
+
+			// nodes the getter doesn't have are retried below
+			if opt.Err == node.ErrNotFound {
+				continue
+			}
+
+			if opt.Err != nil {
+				return nil, opt.Err
+			}
+
+			nd, err := asTxNode(opt.Node, opts)
+			if err != nil {
+				return nil, err
+			}
+			got[opt.Node.Cid().KeyString()] = nd
+			if tx, ok := nd.(*Tx); ok {
+				got[tx.TxidCid().KeyString()] = nd
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	for _, c := range unique {
+		if got[c.KeyString()] != nil {
+			continue
+		}
+
+		nd, err := getOrLookup(ctx, ng, c, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		nd, err = asTxNode(nd, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		if !hasCid(nd, c) {
+			return nil, fmt.Errorf("node fetched for %s has cid %s", c, nd.Cid())
+		}
+		got[c.KeyString()] = nd
+	}
+
+	out := make([]node.Node, len(cids))
+	for i, c := range cids {
+		out[i] = got[c.KeyString()]
+		if out[i] == nil {
+			return nil, fmt.Errorf("transaction tree node %s was not fetched", c)
+		}
+	}
+	return out, nil
+}
+
+// asTxNode decodes nd as a Tx or TxTree if the getter didn't already, or if
+// it is 64 bytes long and opts know better than the getter which it is.
+func asTxNode(nd node.Node, opts DecodeOptions) (node.Node, error) {
+	switch nd.(type) {
+	case *Tx, *TxTree:
+		if opts.TxNodeKind == GuessTxNode || len(nd.RawData()) != 64 {
+			return nd, nil
+		}
+	}
+	return DecodeMaybeTxWithOptions(nd.RawData(), opts)
+}
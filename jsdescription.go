@@ -2,9 +2,35 @@ package ipldzec
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
 )
 
+// Proof systems used by JoinSplit descriptions. Sprout transactions used
+// PHGR13 until Sapling activation, after which v4 transactions carry Groth16
+// proofs.
+const (
+	PHGR13  = "PHGR13"
+	Groth16 = "Groth16"
+)
+
+// jsProofSystem returns the proof system used by the transaction's
+// joinsplits. Only overwintered transactions use the v4 layout, so a legacy
+// transaction keeps PHGR13 proofs whatever its version.
+func (t *Tx) jsProofSystem() string {
+	if t.Overwintered && t.Version >= 4 {
+		return Groth16
+	}
+	return PHGR13
+}
+
+func jsProofSize(system string) int {
+	if system == Groth16 {
+		return 192
+	}
+	return 296
+}
+
 type JSDescription struct {
 	OldVal       uint64
 	NewVal       uint64
@@ -16,15 +42,28 @@ type JSDescription struct {
 	RandomSeed   []byte
 	Macs         [][]byte
 	Proof        []byte
+	ProofSystem  string
 }
 
-func (js *JSDescription) WriteTo(w io.Writer) (int, error) {
+// WriteTo writes the joinsplit as it appears in a transaction whose
+// joinsplits use the given proof system, PHGR13 or Groth16. The proof must be
+// of that system, and so must ProofSystem if it is set.
+func (js *JSDescription) WriteTo(w io.Writer, system string) error {
+	if js.ProofSystem != "" && js.ProofSystem != system {
+		return fmt.Errorf("joinsplit has a %s proof, the transaction needs %s", js.ProofSystem, system)
+	}
+
+	if len(js.Proof) != jsProofSize(system) {
+		return fmt.Errorf("joinsplit proof is %d bytes, %s proofs are %d bytes", len(js.Proof), system, jsProofSize(system))
+	}
+
 	buf := make([]byte, 16)
 
 	binary.LittleEndian.PutUint64(buf, js.OldVal)
 	binary.LittleEndian.PutUint64(buf[8:], js.NewVal)
 
-	return writeMany(w, buf, js.Anchor, js.Nullifiers[0], js.Nullifiers[1], js.Commitments[0], js.Commitments[1], js.EphemeralKey, js.RandomSeed, js.Macs[0], js.Macs[1], js.Proof, js.CipherTexts[0], js.CipherTexts[1])
+	_, err := writeMany(w, buf, js.Anchor, js.Nullifiers[0], js.Nullifiers[1], js.Commitments[0], js.Commitments[1], js.EphemeralKey, js.RandomSeed, js.Macs[0], js.Macs[1], js.Proof, js.CipherTexts[0], js.CipherTexts[1])
+	return err
 }
 
 func writeMany(w io.Writer, bs ...[]byte) (int, error) {
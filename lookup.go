@@ -0,0 +1,109 @@
+package ipldzec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	cid "github.com/ipfs/go-cid"
+	node "github.com/ipfs/go-ipld-format"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// TxLookup is implemented by NodeGetters that can find transactions by txid.
+//
+// Prevouts and TxTree nodes link to transactions with CIDs built from their
+// txids (see Tx.TxidCid). For legacy transactions that is the transaction's
+// CID, but a v5 transaction is stored under the hash of its data, which the
+// txid doesn't give. When getting a txid CID fails with node.ErrNotFound, the
+// Resolver and FetchTransactions look the txid up if the getter supports it.
+type TxLookup interface {
+	// LookupTx returns the CID of the transaction with the given txid, or
+	// node.ErrNotFound if it is not known.
+	LookupTx(ctx context.Context, txid []byte) (cid.Cid, error)
+}
+
+// isTxidCid reports whether c could have been built from a txid.
+func isTxidCid(c cid.Cid) bool {
+	return c.Type() == cid.ZcashTx && c.Prefix().MhType == mh.DBL_SHA2_256
+}
+
+// getOrLookup gets c through ng, looking it up as a txid if ng doesn't have
+// it.
+func getOrLookup(ctx context.Context, ng node.NodeGetter, c cid.Cid, opts DecodeOptions) (node.Node, error) {
+	nd, err := ng.Get(ctx, c)
+	if err != node.ErrNotFound {
+		return nd, err
+	}
+
+	tx, lerr := lookupTx(ctx, ng, c, opts)
+	if lerr == node.ErrNotFound {
+		return nil, err
+	}
+	if lerr != nil {
+		return nil, lerr
+	}
+	return tx, nil
+}
+
+// txidCid fetches the transaction c through ng and returns its TxidCid.
+func txidCid(ctx context.Context, ng node.NodeGetter, c cid.Cid) (cid.Cid, error) {
+	nd, err := ng.Get(ctx, c)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	nd, err = asTxNode(nd, DecodeOptions{TxNodeKind: TxNode})
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	tx, ok := nd.(*Tx)
+	if !ok || !tx.Cid().Equals(c) {
+		return cid.Undef, fmt.Errorf("node fetched for %s is not that transaction", c)
+	}
+	return tx.TxidCid(), nil
+}
+
+// hasCid reports whether nd is the node c links to, either by its CID or,
+// for transactions, by its txid.
+func hasCid(nd node.Node, c cid.Cid) bool {
+	if tx, ok := nd.(*Tx); ok && tx.TxidCid().Equals(c) {
+		return true
+	}
+	return nd.Cid().Equals(c)
+}
+
+// lookupTx finds the transaction with the txid carried by c through ng's
+// TxLookup, and checks that the transaction fetched has that txid. It returns
+// node.ErrNotFound if ng can't look up transactions.
+func lookupTx(ctx context.Context, ng node.NodeGetter, c cid.Cid, opts DecodeOptions) (*Tx, error) {
+	tl, ok := ng.(TxLookup)
+	if !ok || !isTxidCid(c) {
+		return nil, node.ErrNotFound
+	}
+
+	txid := cidToHash(c)
+	tc, err := tl.LookupTx(ctx, txid)
+	if err != nil {
+		return nil, err
+	}
+
+	nd, err := ng.Get(ctx, tc)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.TxNodeKind = TxNode
+	nd, err = asTxNode(nd, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, ok := nd.(*Tx)
+	if !ok || !tx.Cid().Equals(tc) || !bytes.Equal(tx.ZecSha(), txid) {
+		return nil, fmt.Errorf("node looked up for txid %s is not that transaction", c)
+	}
+
+	return tx, nil
+}
@@ -0,0 +1,87 @@
+package ipldzec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// ErrMutatedMerkleTree is returned when a block's transaction list contains
+// a duplicated pair that would give the same merkle root as a shorter list
+// (CVE-2012-2459). Such a block must be rejected even if its root matches.
+var ErrMutatedMerkleTree = errors.New("merkle tree is mutated by duplicate transactions")
+
+// MerkleRootError is returned when a block's transactions do not hash to the
+// merkle root committed to in its header.
+type MerkleRootError struct {
+	Header   []byte
+	Computed []byte
+}
+
+func (e *MerkleRootError) Error() string {
+	return fmt.Sprintf("computed merkle root %x does not match header merkle root %x", revString(e.Computed), revString(e.Header))
+}
+
+func dblSha(b []byte) []byte {
+	first := sha256.Sum256(b)
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
+
+// merkleRoot computes the root of the merkle tree over the given hashes, the
+// same tree mkMerkleTree builds. It also reports whether any layer contains
+// an identical adjacent pair, which makes the tree indistinguishable from
+// one with the last transactions duplicated.
+func merkleRoot(hashes [][]byte) ([]byte, bool) {
+	if len(hashes) == 0 {
+		return nil, false
+	}
+
+	var mutated bool
+	layer := hashes
+	for len(layer) > 1 {
+		for i := 0; i+1 < len(layer); i += 2 {
+			if bytes.Equal(layer[i], layer[i+1]) {
+				mutated = true
+			}
+		}
+
+		if len(layer)%2 != 0 {
+			layer = append(layer[:len(layer):len(layer)], layer[len(layer)-1])
+		}
+
+		next := make([][]byte, len(layer)/2)
+		for i := range next {
+			next[i] = dblSha(append(append([]byte{}, layer[2*i]...), layer[2*i+1]...))
+		}
+		layer = next
+	}
+
+	return layer[0], mutated
+}
+
+// CheckMerkleRoot verifies that the given transactions, in block order, hash
+// to the block's merkle root and do not form a mutated tree.
+func (b *Block) CheckMerkleRoot(txs []*Tx) error {
+	if len(txs) == 0 {
+		return fmt.Errorf("block has no transactions")
+	}
+
+	hashes := make([][]byte, len(txs))
+	for i, tx := range txs {
+		hashes[i] = tx.ZecSha()
+	}
+
+	root, mutated := merkleRoot(hashes)
+	if mutated {
+		return ErrMutatedMerkleTree
+	}
+
+	header := cidToHash(b.MerkleRoot)
+	if !bytes.Equal(root, header) {
+		return &MerkleRootError{Header: header, Computed: root}
+	}
+
+	return nil
+}
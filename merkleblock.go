@@ -0,0 +1,316 @@
+package ipldzec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	cid "github.com/ipfs/go-cid"
+	node "github.com/ipfs/go-ipld-format"
+)
+
+// PartialMerkleTree proves the inclusion of a subset of a block's
+// transactions, as carried by the merkleblock message. The tree is walked
+// depth first; each flag says whether the node is an ancestor of a matched
+// transaction, and Hashes holds the nodes that are not descended into.
+type PartialMerkleTree struct {
+	Transactions uint32
+	Hashes       [][]byte
+	Flags        []bool
+}
+
+// NewPartialMerkleTree builds a partial merkle tree over a block's ordered
+// transactions, proving those whose Cids or TxidCids are in match.
+func NewPartialMerkleTree(txs []*Tx, match []cid.Cid) (*PartialMerkleTree, error) {
+	if len(txs) == 0 {
+		return nil, fmt.Errorf("block has no transactions")
+	}
+
+	nds := make([]node.Node, len(txs))
+	for i, tx := range txs {
+		nds[i] = tx
+	}
+
+	trees, err := mkMerkleTree(nds)
+	if err != nil {
+		return nil, err
+	}
+
+	pmt := &PartialMerkleTree{Transactions: uint32(len(txs))}
+
+	// lay the tree out by height: leaves first, then each layer of TxTree
+	// nodes in the order mkMerkleTree produced them
+	layers := [][][]byte{make([][]byte, len(txs))}
+	matched := make([]bool, len(txs))
+	for i, tx := range txs {
+		layers[0][i] = tx.ZecSha()
+		for _, m := range match {
+			if m.Equals(tx.Cid()) || m.Equals(tx.TxidCid()) {
+				matched[i] = true
+			}
+		}
+	}
+
+	for h := 1; pmt.width(h-1) > 1; h++ {
+		layer := make([][]byte, pmt.width(h))
+		for i := range layer {
+			layer[i] = trees[0].ZECSha()
+			trees = trees[1:]
+		}
+		layers = append(layers, layer)
+	}
+
+	pmt.build(len(layers)-1, 0, layers, matched)
+	return pmt, nil
+}
+
+func (pmt *PartialMerkleTree) width(height int) int {
+	return int((uint64(pmt.Transactions) + (1 << uint(height)) - 1) >> uint(height))
+}
+
+func (pmt *PartialMerkleTree) build(height, pos int, layers [][][]byte, matched []bool) {
+	var parentOfMatch bool
+	for p := pos << uint(height); p < (pos+1)<<uint(height) && p < len(matched); p++ {
+		parentOfMatch = parentOfMatch || matched[p]
+	}
+
+	pmt.Flags = append(pmt.Flags, parentOfMatch)
+
+	if height == 0 || !parentOfMatch {
+		pmt.Hashes = append(pmt.Hashes, layers[height][pos])
+		return
+	}
+
+	pmt.build(height-1, pos*2, layers, matched)
+	if pos*2+1 < pmt.width(height-1) {
+		pmt.build(height-1, pos*2+1, layers, matched)
+	}
+}
+
+// ExtractMatches validates the tree and returns the merkle root it commits
+// to, along with the TxidCids of the matched transactions and their positions
+// in the block.
+func (pmt *PartialMerkleTree) ExtractMatches() ([]byte, []cid.Cid, []uint32, error) {
+	if pmt.Transactions == 0 {
+		return nil, nil, nil, fmt.Errorf("partial merkle tree has no transactions")
+	}
+
+	if pmt.Transactions > MaxBlockSize/60 {
+		return nil, nil, nil, fmt.Errorf("partial merkle tree has too many transactions")
+	}
+
+	if len(pmt.Hashes) > int(pmt.Transactions) {
+		return nil, nil, nil, fmt.Errorf("partial merkle tree has more hashes than transactions")
+	}
+
+	if len(pmt.Flags) < len(pmt.Hashes) {
+		return nil, nil, nil, fmt.Errorf("partial merkle tree has fewer flags than hashes")
+	}
+
+	height := 0
+	for pmt.width(height) > 1 {
+		height++
+	}
+
+	ex := &pmtExtractor{pmt: pmt}
+	root, err := ex.extract(height, 0)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if (ex.bitsUsed+7)/8 != (len(pmt.Flags)+7)/8 {
+		return nil, nil, nil, fmt.Errorf("partial merkle tree has unused flags")
+	}
+
+	if ex.hashesUsed != len(pmt.Hashes) {
+		return nil, nil, nil, fmt.Errorf("partial merkle tree has unused hashes")
+	}
+
+	return root, ex.matches, ex.indices, nil
+}
+
+type pmtExtractor struct {
+	pmt        *PartialMerkleTree
+	bitsUsed   int
+	hashesUsed int
+	matches    []cid.Cid
+	indices    []uint32
+}
+
+func (ex *pmtExtractor) extract(height, pos int) ([]byte, error) {
+	if ex.bitsUsed >= len(ex.pmt.Flags) {
+		return nil, fmt.Errorf("partial merkle tree ran out of flags")
+	}
+
+	parentOfMatch := ex.pmt.Flags[ex.bitsUsed]
+	ex.bitsUsed++
+
+	if height == 0 || !parentOfMatch {
+		if ex.hashesUsed >= len(ex.pmt.Hashes) {
+			return nil, fmt.Errorf("partial merkle tree ran out of hashes")
+		}
+
+		h := ex.pmt.Hashes[ex.hashesUsed]
+		ex.hashesUsed++
+
+		if height == 0 && parentOfMatch {
+			ex.matches = append(ex.matches, hashToCid(h, cid.ZcashTx))
+			ex.indices = append(ex.indices, uint32(pos))
+		}
+		return h, nil
+	}
+
+	left, err := ex.extract(height-1, pos*2)
+	if err != nil {
+		return nil, err
+	}
+
+	right := left
+	if pos*2+1 < ex.pmt.width(height-1) {
+		right, err = ex.extract(height-1, pos*2+1)
+		if err != nil {
+			return nil, err
+		}
+
+		// identical siblings are only allowed from padding (CVE-2012-2459)
+		if bytes.Equal(left, right) {
+			return nil, ErrMutatedMerkleTree
+		}
+	}
+
+	return dblSha(append(append([]byte{}, left...), right...)), nil
+}
+
+// WriteTo serializes the tree as it appears in a merkleblock message.
+func (pmt *PartialMerkleTree) WriteTo(w io.Writer) (int, error) {
+	buf := new(bytes.Buffer)
+
+	n := make([]byte, 4)
+	binary.LittleEndian.PutUint32(n, pmt.Transactions)
+	buf.Write(n)
+
+	writeVarInt(buf, uint64(len(pmt.Hashes)))
+	for _, h := range pmt.Hashes {
+		buf.Write(h)
+	}
+
+	flags := make([]byte, (len(pmt.Flags)+7)/8)
+	for i, f := range pmt.Flags {
+		if f {
+			flags[i/8] |= 1 << uint(i%8)
+		}
+	}
+	writeVarInt(buf, uint64(len(flags)))
+	buf.Write(flags)
+
+	return w.Write(buf.Bytes())
+}
+
+func readPartialMerkleTree(r *bytes.Reader) (*PartialMerkleTree, error) {
+	n, err := readBuf(r, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	pmt := &PartialMerkleTree{Transactions: binary.LittleEndian.Uint32(n)}
+
+	nHashes, err := readVarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if nHashes > uint64(r.Len()/32) {
+		return nil, fmt.Errorf("partial merkle tree hash count exceeds message size")
+	}
+
+	for i := uint64(0); i < nHashes; i++ {
+		h, err := readBuf(r, 32)
+		if err != nil {
+			return nil, err
+		}
+		pmt.Hashes = append(pmt.Hashes, h)
+	}
+
+	nFlagBytes, err := readVarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if nFlagBytes > uint64(r.Len()) {
+		return nil, fmt.Errorf("partial merkle tree flags exceed message size")
+	}
+
+	flags, err := readBuf(r, int(nFlagBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(flags)*8; i++ {
+		pmt.Flags = append(pmt.Flags, flags[i/8]&(1<<uint(i%8)) != 0)
+	}
+
+	return pmt, nil
+}
+
+// MerkleBlock is a block header together with a partial merkle tree proving
+// some of its transactions, the payload of the merkleblock message.
+type MerkleBlock struct {
+	Header *Block
+	Tree   *PartialMerkleTree
+}
+
+// NewMerkleBlock builds a merkleblock for the given block and transactions,
+// proving the inclusion of those whose Cids or TxidCids are in match.
+func NewMerkleBlock(blk *Block, txs []*Tx, match []cid.Cid) (*MerkleBlock, error) {
+	pmt, err := NewPartialMerkleTree(txs, match)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MerkleBlock{Header: blk, Tree: pmt}, nil
+}
+
+// EncodeMerkleBlock serializes a merkleblock message.
+func EncodeMerkleBlock(mb *MerkleBlock) []byte {
+	buf := bytes.NewBuffer(mb.Header.header())
+	mb.Tree.WriteTo(buf)
+	return buf.Bytes()
+}
+
+// DecodeMerkleBlock parses a merkleblock message. Bytes left over after the
+// partial merkle tree are rejected with ErrTrailingData.
+func DecodeMerkleBlock(b []byte) (*MerkleBlock, error) {
+	r := bytes.NewReader(b)
+	blk, err := ReadBlock(r)
+	if err != nil {
+		return nil, err
+	}
+
+	pmt, err := readPartialMerkleTree(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Len() != 0 {
+		return nil, &DecodeError{Offset: int64(len(b) - r.Len()), Field: "merkleblock", Err: ErrTrailingData}
+	}
+
+	return &MerkleBlock{Header: blk, Tree: pmt}, nil
+}
+
+// Verify checks the partial merkle tree against the header's merkle root and
+// returns the TxidCids and block positions of the matched transactions.
+func (mb *MerkleBlock) Verify() ([]cid.Cid, []uint32, error) {
+	root, matches, indices, err := mb.Tree.ExtractMatches()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := cidToHash(mb.Header.MerkleRoot)
+	if !bytes.Equal(root, header) {
+		return nil, nil, &MerkleRootError{Header: header, Computed: root}
+	}
+
+	return matches, indices, nil
+}
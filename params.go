@@ -0,0 +1,111 @@
+package ipldzec
+
+import (
+	"math/big"
+
+	script "github.com/ipfs/go-ipld-zcash/script"
+)
+
+// MaxBlockSize is the consensus limit on the serialized size of a block.
+const MaxBlockSize = 2000000
+
+// Consensus limits on the serialized size of a transaction. Before Sapling
+// activation a transaction could be at most MaxTxSizeBeforeSapling bytes;
+// since then it is only bounded by the size of the block, MaxTxSize.
+const (
+	MaxTxSizeBeforeSapling = 100000
+	MaxTxSize              = MaxBlockSize
+)
+
+// Params describes the network upgrade schedule of a Zcash network. The
+// meaning of some header fields depends on which upgrades are active at a
+// given height.
+type Params struct {
+	Name string
+
+	// Addresses holds the transparent address prefixes of the network.
+	Addresses *script.Network
+
+	// PowLimit is the easiest target a block may have.
+	PowLimit *big.Int
+
+	// MinDifficultyBlocksAfterHeight enables the testnet rule allowing
+	// minimum difficulty blocks after long gaps. Zero disables it.
+	MinDifficultyBlocksAfterHeight uint32
+
+	OverwinterHeight uint32
+	SaplingHeight    uint32
+	BlossomHeight    uint32
+	HeartwoodHeight  uint32
+	CanopyHeight     uint32
+	NU5Height        uint32
+}
+
+var MainNetParams = &Params{
+	Name:      "mainnet",
+	Addresses: script.MainNet,
+	PowLimit:  hexToBig("0007ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+
+	OverwinterHeight: 347500,
+	SaplingHeight:    419200,
+	BlossomHeight:    653600,
+	HeartwoodHeight:  903000,
+	CanopyHeight:     1046400,
+	NU5Height:        1687104,
+}
+
+var TestNetParams = &Params{
+	Name:      "testnet",
+	Addresses: script.TestNet,
+	PowLimit:  hexToBig("07ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+
+	MinDifficultyBlocksAfterHeight: 299187,
+
+	OverwinterHeight: 207500,
+	SaplingHeight:    280000,
+	BlossomHeight:    584000,
+	HeartwoodHeight:  903800,
+	CanopyHeight:     1028500,
+	NU5Height:        1842420,
+}
+
+// Names under which Block.ReservedHash is exposed, depending on the network
+// upgrade active at the block's height.
+const (
+	ReservedField         = "reserved"
+	FinalSaplingRootField = "finalSaplingRoot"
+	LightClientRootField  = "lightClientRoot"
+	BlockCommitmentsField = "blockCommitments"
+)
+
+// reservedField returns the name of the commitment stored in the header's
+// reserved hash at the given height.
+func (p *Params) reservedField(height uint32) string {
+	switch {
+	case height >= p.NU5Height:
+		return BlockCommitmentsField
+	case height >= p.HeartwoodHeight:
+		return LightClientRootField
+	case height >= p.SaplingHeight:
+		return FinalSaplingRootField
+	default:
+		return ReservedField
+	}
+}
+
+// maxTxSize returns the transaction size limit in a block at the given
+// height.
+func (p *Params) maxTxSize(height uint32) int {
+	if height < p.SaplingHeight {
+		return MaxTxSizeBeforeSapling
+	}
+	return MaxTxSize
+}
+
+func hexToBig(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("invalid hex number: " + s)
+	}
+	return n
+}
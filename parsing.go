@@ -11,26 +11,111 @@ import (
 	mh "github.com/multiformats/go-multihash"
 )
 
-func DecodeBlockMessage(b []byte) ([]node.Node, error) {
-	r := bytes.NewReader(b)
-	blk, err := ReadBlock(r)
-	if err != nil {
-		return nil, err
+// DecodeOptions control how serialized blocks and transactions are decoded.
+// The zero value decodes mainnet data.
+type DecodeOptions struct {
+	// Params is the network the data belongs to. It is recorded on the decoded
+	// nodes and used to derive addresses and interpret the reserved hash.
+	Params *Params
+
+	// VerifyMerkleRoot makes DecodeBlockMessage check that the decoded
+	// transactions hash to the header's merkle root, returning a
+	// *MerkleRootError or ErrMutatedMerkleTree if they don't.
+	VerifyMerkleRoot bool
+
+	// MaxBlockSize and MaxTxSize bound the size of a block message and of
+	// each transaction, and so every length and count read from the input.
+	// Zero means the consensus limits: MaxBlockSize, and MaxTxSize or, once
+	// a block's height is read from its coinbase, the limit at that height.
+	MaxBlockSize int
+	MaxTxSize    int
+
+	// Strict rejects input that is not exactly the encoding of the decoded
+	// nodes: trailing bytes, and anything RawData would serialize
+	// differently. This guarantees a node's CID matches the bytes it was
+	// decoded from. Errors wrap ErrTrailingData or ErrNonCanonical.
+	Strict bool
+
+	// TxNodeKind says which node 64 byte zcash-tx data is, when the caller
+	// knows from context. See DecodeMaybeTxWithOptions.
+	TxNodeKind TxNodeKind
+}
+
+// TxNodeKind distinguishes the nodes sharing the zcash-tx codec.
+type TxNodeKind int
+
+const (
+	// GuessTxNode decodes 64 byte data as a transaction if it strictly
+	// decodes as one, and as a TxTree otherwise.
+	GuessTxNode TxNodeKind = iota
+	TxNode
+	TxTreeNode
+)
+
+func (o DecodeOptions) params() *Params {
+	if o.Params == nil {
+		return MainNetParams
 	}
+	return o.Params
+}
+
+func (o DecodeOptions) maxBlockSize() int {
+	if o.MaxBlockSize == 0 {
+		return MaxBlockSize
+	}
+	return o.MaxBlockSize
+}
+
+func (o DecodeOptions) maxTxSize() int {
+	if o.MaxTxSize == 0 {
+		return MaxTxSize
+	}
+	return o.MaxTxSize
+}
+
+// maxTxSizeAt is maxTxSize for a transaction in a block at height, where
+// zero means the height is unknown.
+func (o DecodeOptions) maxTxSizeAt(height uint32) int {
+	if o.MaxTxSize != 0 || height == 0 {
+		return o.maxTxSize()
+	}
+	return o.params().maxTxSize(height)
+}
+
+func DecodeBlockMessage(b []byte) ([]node.Node, error) {
+	return DecodeBlockMessageWithOptions(b, DecodeOptions{})
+}
 
-	nTx, err := readVarint(r)
+func DecodeBlockMessageWithOptions(b []byte, opts DecodeOptions) ([]node.Node, error) {
+	d := NewDecoderWithOptions(bytes.NewReader(b), opts)
+	blk, _, err := d.ReadHeader()
 	if err != nil {
 		return nil, err
 	}
 
 	var txs []node.Node
-	for i := 0; i < nTx; i++ {
-		tx, err := readTx(r)
+	var rawtxs []*Tx
+	for {
+		tx, _, err := d.ReadTx()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
 			return nil, err
 		}
 
 		txs = append(txs, tx)
+		rawtxs = append(rawtxs, tx)
+	}
+
+	if err := d.r.checkEnd(opts.Strict, len(b), "block"); err != nil {
+		return nil, err
+	}
+
+	if opts.VerifyMerkleRoot {
+		if err := blk.CheckMerkleRoot(rawtxs); err != nil {
+			return nil, err
+		}
 	}
 
 	txtrees, err := mkMerkleTree(txs)
@@ -50,6 +135,63 @@ func DecodeBlockMessage(b []byte) ([]node.Node, error) {
 	return out, nil
 }
 
+// EncodeBlockMessage serializes a block header and its transactions, in
+// block order, as a block message. It is the inverse of DecodeBlockMessage,
+// and fails if the transactions do not hash to the header's merkle root.
+func EncodeBlockMessage(blk *Block, txs []*Tx) ([]byte, error) {
+	if err := blk.CheckMerkleRoot(txs); err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(blk.header())
+	writeVarInt(buf, uint64(len(txs)))
+	for _, tx := range txs {
+		b, err := EncodeTx(tx)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+	}
+
+	if buf.Len() > MaxBlockSize {
+		return nil, fmt.Errorf("block message is %d bytes, over the %d byte limit", buf.Len(), MaxBlockSize)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// coinbaseHeight extracts the block height that BIP34 requires at the start
+// of the coinbase input script. It returns zero if none can be found.
+func coinbaseHeight(tx *Tx) uint32 {
+	if len(tx.Inputs) != 1 || tx.Inputs[0].PrevTx.Defined() {
+		return 0
+	}
+
+	script := tx.Inputs[0].Script
+	if len(script) == 0 {
+		return 0
+	}
+
+	op := script[0]
+	switch {
+	case op >= 0x51 && op <= 0x60: // OP_1 through OP_16
+		return uint32(op - 0x50)
+	case op >= 1 && op <= 4 && len(script) > int(op):
+		num := script[1 : 1+op]
+		if num[len(num)-1]&0x80 != 0 {
+			return 0
+		}
+
+		var h uint32
+		for i := len(num) - 1; i >= 0; i-- {
+			h = h<<8 | uint32(num[i])
+		}
+		return h
+	default:
+		return 0
+	}
+}
+
 func mkMerkleTree(txs []node.Node) ([]*TxTree, error) {
 	var out []*TxTree
 	var next []node.Node
@@ -64,8 +206,8 @@ func mkMerkleTree(txs []node.Node) ([]*TxTree, error) {
 			right = layer[(i*2)+1]
 
 			t := &TxTree{
-				Left:  &node.Link{Cid: left.Cid()},
-				Right: &node.Link{Cid: right.Cid()},
+				Left:  &node.Link{Cid: treeLink(left)},
+				Right: &node.Link{Cid: treeLink(right)},
 			}
 
 			out = append(out, t)
@@ -79,22 +221,59 @@ func mkMerkleTree(txs []node.Node) ([]*TxTree, error) {
 	return out, nil
 }
 
+// treeLink returns the CID a TxTree node uses to link to nd. Transactions
+// are linked by txid.
+func treeLink(nd node.Node) cid.Cid {
+	if tx, ok := nd.(*Tx); ok {
+		return tx.TxidCid()
+	}
+	return nd.Cid()
+}
+
 func DecodeBlock(b []byte) (*Block, error) {
-	return ReadBlock(bytes.NewReader(b))
+	return DecodeBlockWithOptions(b, DecodeOptions{})
 }
 
-func ReadBlock(r *bytes.Reader) (*Block, error) {
+func DecodeBlockWithOptions(b []byte, opts DecodeOptions) (*Block, error) {
+	r := newReader(bytes.NewReader(b))
+	r.limitTo(int64(opts.maxBlockSize()))
+	r.record(opts.Strict)
+	blk, err := readBlock(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.checkRecorded(0, "header", blk.RawData()); err != nil {
+		return nil, err
+	}
+
+	if err := r.checkEnd(opts.Strict, len(b), "header"); err != nil {
+		return nil, err
+	}
+
+	blk.Params = opts.params()
+	return blk, nil
+}
+
+// ReadBlock reads a block header from r. It reads exactly the bytes of the
+// header, so r may be positioned at the transactions of a block message
+// afterwards.
+func ReadBlock(r io.Reader) (*Block, error) {
+	rd := newReader(r)
+	rd.limitTo(MaxBlockSize)
+	return readBlock(rd)
+}
+
+func readBlock(r *reader) (*Block, error) {
 	var blk Block
+	var err error
 
-	version := make([]byte, 4)
-	_, err := io.ReadFull(r, version)
+	blk.Version, err = r.uint32("version")
 	if err != nil {
 		return nil, err
 	}
-	blk.Version = binary.LittleEndian.Uint32(version)
 
-	prevBlock := make([]byte, 32)
-	_, err = io.ReadFull(r, prevBlock)
+	prevBlock, err := r.buf("parent", 32)
 	if err != nil {
 		return nil, err
 	}
@@ -102,67 +281,95 @@ func ReadBlock(r *bytes.Reader) (*Block, error) {
 	blkhash, _ := mh.Encode(prevBlock, mh.DBL_SHA2_256)
 	blk.Parent = cid.NewCidV1(cid.ZcashBlock, blkhash)
 
-	merkleRoot := make([]byte, 32)
-	_, err = io.ReadFull(r, merkleRoot)
+	merkleRoot, err := r.buf("tx", 32)
 	if err != nil {
 		return nil, err
 	}
 	txroothash, _ := mh.Encode(merkleRoot, mh.DBL_SHA2_256)
 	blk.MerkleRoot = cid.NewCidV1(cid.ZcashTx, txroothash)
 
-	reserved := make([]byte, 32)
-	_, err = io.ReadFull(r, reserved)
+	blk.ReservedHash, err = r.buf("reserved", 32)
 	if err != nil {
 		return nil, err
 	}
-	blk.ReservedHash = reserved
 
-	timestamp := make([]byte, 4)
-	_, err = io.ReadFull(r, timestamp)
+	blk.Timestamp, err = r.uint32("timestamp")
 	if err != nil {
 		return nil, err
 	}
-	blk.Timestamp = binary.LittleEndian.Uint32(timestamp)
 
-	diff := make([]byte, 4)
-	_, err = io.ReadFull(r, diff)
+	blk.Difficulty, err = r.uint32("difficulty")
 	if err != nil {
 		return nil, err
 	}
-	blk.Difficulty = binary.LittleEndian.Uint32(diff)
 
-	nonce := make([]byte, 32)
-	_, err = io.ReadFull(r, nonce)
+	blk.Nonce, err = r.buf("nonce", 32)
 	if err != nil {
 		return nil, err
 	}
-	blk.Nonce = nonce
 
-	sollen, err := readVarint(r)
+	blk.Solution, err = r.bytes("solution")
 	if err != nil {
 		return nil, err
 	}
 
-	solution := make([]byte, sollen)
-	_, err = io.ReadFull(r, solution)
-	if err != nil {
-		return nil, err
-	}
-	blk.Solution = solution
-
 	return &blk, nil
 }
 
 func DecodeMaybeTx(b []byte) (node.Node, error) {
-	if len(b) == 64 {
+	return DecodeMaybeTxWithOptions(b, DecodeOptions{})
+}
+
+// DecodeMaybeTxWithOptions decodes a transaction or a TxTree node. A TxTree
+// is two hashes, 64 bytes, but a legacy transaction can also be 64 bytes
+// long, and both are addressed by the double SHA-256 of their data. Unless
+// opts.TxNodeKind says otherwise, 64 byte data is taken to be a transaction
+// if it strictly decodes as a pre-Overwinter transaction with both inputs
+// and outputs, which no valid 64 byte transaction lacks.
+func DecodeMaybeTxWithOptions(b []byte, opts DecodeOptions) (node.Node, error) {
+	if len(b) != 64 {
+		return DecodeTxWithOptions(b, opts)
+	}
+
+	switch opts.TxNodeKind {
+	case TxNode:
+		return DecodeTxWithOptions(b, opts)
+	case TxTreeNode:
 		return DecodeTxTree(b)
 	}
-	return DecodeTx(b)
+
+	strict := opts
+	strict.Strict = true
+	tx, err := DecodeTxWithOptions(b, strict)
+	if err == nil && !tx.Overwintered && len(tx.Inputs) > 0 && len(tx.Outputs) > 0 {
+		return tx, nil
+	}
+	return DecodeTxTree(b)
 }
 
 func DecodeTx(b []byte) (*Tx, error) {
-	r := bytes.NewReader(b)
-	return readTx(r)
+	return DecodeTxWithOptions(b, DecodeOptions{})
+}
+
+func DecodeTxWithOptions(b []byte, opts DecodeOptions) (*Tx, error) {
+	r := newReader(bytes.NewReader(b))
+	r.limitTo(int64(opts.maxTxSize()))
+	r.record(opts.Strict)
+	tx, err := readTx(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.checkRecorded(0, "tx", tx.RawData()); err != nil {
+		return nil, err
+	}
+
+	if err := r.checkEnd(opts.Strict, len(b), "tx"); err != nil {
+		return nil, err
+	}
+
+	tx.Params = opts.params()
+	return tx, nil
 }
 
 func DecodeTxTree(b []byte) (*TxTree, error) {
@@ -178,129 +385,394 @@ func DecodeTxTree(b []byte) (*TxTree, error) {
 	}, nil
 }
 
-func readTx(r *bytes.Reader) (*Tx, error) {
+// Serialized sizes of the smallest possible transaction and vector
+// elements, used to reject counts that could not fit in the remaining data.
+const (
+	minTxSize          = 10
+	minTxInSize        = 41
+	minTxOutSize       = 9
+	spendV4Size        = 384
+	outputV4Size       = 948
+	spendV5Size        = 96 + 192 + 64
+	outputV5Size       = 756 + 192
+	minJoinSplitSize   = 1506 + 192
+	orchardActionSize  = 820 + 64
+	jsCipherTextsSize  = 1202
+	orchardFixedFields = 41
+)
+
+func readTx(r *reader) (*Tx, error) {
 	var out Tx
 
-	version := make([]byte, 4)
-	_, err := io.ReadFull(r, version)
+	header, err := r.uint32("header")
 	if err != nil {
 		return nil, err
 	}
-	out.Version = binary.LittleEndian.Uint32(version)
+	out.Overwintered = header>>31 == 1
+	out.Version = header & 0x7fffffff
+
+	if out.Overwintered {
+		off := r.n
+		out.VersionGroupID, err = r.uint32("versionGroupId")
+		if err != nil {
+			return nil, err
+		}
 
-	inCtr, err := readVarint(r)
+		switch {
+		case out.Version == 3 && out.VersionGroupID == OverwinterVersionGroupID:
+		case out.Version == 4 && out.VersionGroupID == SaplingVersionGroupID:
+		case out.Version == 5 && out.VersionGroupID == NU5VersionGroupID:
+			return readTxV5(r, &out)
+		default:
+			return nil, r.fail(off, "versionGroupId", fmt.Errorf("unsupported overwintered transaction version %d (group id %x)", out.Version, out.VersionGroupID))
+		}
+	}
+
+	out.Inputs, err = readTxIns(r)
 	if err != nil {
 		return nil, err
 	}
 
-	for i := 0; i < inCtr; i++ {
-		txin, err := parseTxIn(r)
+	out.Outputs, err = readTxOuts(r)
+	if err != nil {
+		return nil, err
+	}
+
+	out.LockTime, err = r.uint32("lockTime")
+	if err != nil {
+		return nil, err
+	}
+
+	if out.Overwintered {
+		out.ExpiryHeight, err = r.uint32("expiryHeight")
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		out.Inputs = append(out.Inputs, txin)
+	if out.Version < 2 {
+		return &out, nil
 	}
 
-	outCtr, err := readVarint(r)
+	if out.isV4() {
+		valueBalance, err := r.uint64("valueBalance")
+		if err != nil {
+			return nil, err
+		}
+		out.ValueBalance = int64(valueBalance)
+
+		nSpend, err := r.count("shieldedSpends", spendV4Size)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 0; i < nSpend; i++ {
+			sd, err := readSpendDescription(r)
+			if err != nil {
+				return nil, err
+			}
+
+			out.ShieldedSpends = append(out.ShieldedSpends, sd)
+		}
+
+		nOutput, err := r.count("shieldedOutputs", outputV4Size)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 0; i < nOutput; i++ {
+			od, err := readOutputDescription(r)
+			if err != nil {
+				return nil, err
+			}
+
+			out.ShieldedOutputs = append(out.ShieldedOutputs, od)
+		}
+	}
+
+	nJoinSplit, err := r.count("joinSplits", minJoinSplitSize)
 	if err != nil {
 		return nil, err
 	}
 
-	for i := 0; i < outCtr; i++ {
-		txout, err := parseTxOut(r)
+	var joinsplits []*JSDescription
+	for i := 0; i < nJoinSplit; i++ {
+		js, err := readJoinSplit(r, out.jsProofSystem())
+		if err != nil {
+			return nil, err
+		}
+
+		joinsplits = append(joinsplits, js)
+	}
+	out.JoinSplits = joinsplits
+
+	if nJoinSplit > 0 {
+		out.JSPubKey, err = r.buf("joinSplitPubKey", 32)
+		if err != nil {
+			return nil, err
+		}
+
+		out.JSSig, err = r.buf("joinSplitSig", 64)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		out.Outputs = append(out.Outputs, txout)
+	if len(out.ShieldedSpends)+len(out.ShieldedOutputs) > 0 {
+		out.BindingSig, err = r.buf("bindingSig", 64)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	lock_time := make([]byte, 4)
-	_, err = io.ReadFull(r, lock_time)
+	return &out, nil
+}
+
+// readTxV5 reads the remainder of a ZIP 225 transaction, following the
+// header and version group id already consumed by readTx.
+func readTxV5(r *reader, out *Tx) (*Tx, error) {
+	var err error
+	out.ConsensusBranchID, err = r.uint32("consensusBranchId")
 	if err != nil {
 		return nil, err
 	}
 
-	out.LockTime = binary.LittleEndian.Uint32(lock_time)
+	out.LockTime, err = r.uint32("lockTime")
+	if err != nil {
+		return nil, err
+	}
 
-	if out.Version == 1 {
-		return &out, nil
+	out.ExpiryHeight, err = r.uint32("expiryHeight")
+	if err != nil {
+		return nil, err
 	}
 
-	nJoinSplit, err := readVarint(r)
+	out.Inputs, err = readTxIns(r)
 	if err != nil {
 		return nil, err
 	}
 
-	var joinsplits []*JSDescription
-	for i := 0; i < nJoinSplit; i++ {
-		js, err := readJoinSplit(r)
+	out.Outputs, err = readTxOuts(r)
+	if err != nil {
+		return nil, err
+	}
+
+	nSpend, err := r.count("shieldedSpends", spendV5Size)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < nSpend; i++ {
+		buf, err := r.buf("shieldedSpend", 96)
 		if err != nil {
 			return nil, err
 		}
 
-		joinsplits = append(joinsplits, js)
+		out.ShieldedSpends = append(out.ShieldedSpends, &SpendDescription{
+			Cv:        buf[:32],
+			Nullifier: buf[32:64],
+			Rk:        buf[64:],
+		})
 	}
-	out.JoinSplits = joinsplits
 
-	jsPubK := make([]byte, 32)
-	_, err = io.ReadFull(r, jsPubK)
+	nOutput, err := r.count("shieldedOutputs", outputV5Size)
 	if err != nil {
 		return nil, err
 	}
-	out.JSPubKey = jsPubK
 
-	jsSig := make([]byte, 64)
-	_, err = io.ReadFull(r, jsSig)
+	for i := 0; i < nOutput; i++ {
+		buf, err := r.buf("shieldedOutput", 756)
+		if err != nil {
+			return nil, err
+		}
+
+		out.ShieldedOutputs = append(out.ShieldedOutputs, &OutputDescription{
+			Cv:            buf[:32],
+			Cmu:           buf[32:64],
+			EphemeralKey:  buf[64:96],
+			EncCiphertext: buf[96:676],
+			OutCiphertext: buf[676:],
+		})
+	}
+
+	if nSpend+nOutput > 0 {
+		valueBalance, err := r.uint64("valueBalance")
+		if err != nil {
+			return nil, err
+		}
+		out.ValueBalance = int64(valueBalance)
+	}
+
+	if nSpend > 0 {
+		anchor, err := r.buf("anchor", 32)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, sd := range out.ShieldedSpends {
+			sd.Anchor = anchor
+		}
+	}
+
+	for _, sd := range out.ShieldedSpends {
+		sd.Proof, err = r.buf("spendProof", 192)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, sd := range out.ShieldedSpends {
+		sd.SpendAuthSig, err = r.buf("spendAuthSig", 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, od := range out.ShieldedOutputs {
+		od.Proof, err = r.buf("outputProof", 192)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if nSpend+nOutput > 0 {
+		out.BindingSig, err = r.buf("bindingSig", 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	orchard, err := readOrchardBundle(r)
 	if err != nil {
 		return nil, err
 	}
-	out.JSSig = jsSig
+	out.Orchard = orchard
 
-	return &out, nil
+	return out, nil
 }
 
-func parseTxIn(r *bytes.Reader) (*TxIn, error) {
-	prevTxHash := make([]byte, 32)
-	_, err := io.ReadFull(r, prevTxHash)
+func readOrchardBundle(r *reader) (*OrchardBundle, error) {
+	nActions, err := r.count("orchard/actions", orchardActionSize)
 	if err != nil {
 		return nil, err
 	}
 
-	prevTxIndex := make([]byte, 4)
-	_, err = io.ReadFull(r, prevTxIndex)
+	if nActions == 0 {
+		return nil, nil
+	}
+
+	var ob OrchardBundle
+	for i := 0; i < nActions; i++ {
+		buf, err := r.buf("orchard/action", 820)
+		if err != nil {
+			return nil, err
+		}
+
+		ob.Actions = append(ob.Actions, &OrchardAction{
+			Cv:            buf[:32],
+			Nullifier:     buf[32:64],
+			Rk:            buf[64:96],
+			Cmx:           buf[96:128],
+			EphemeralKey:  buf[128:160],
+			EncCiphertext: buf[160:740],
+			OutCiphertext: buf[740:],
+		})
+	}
+
+	fixed, err := r.buf("orchard/flags", orchardFixedFields)
 	if err != nil {
 		return nil, err
 	}
+	ob.Flags = fixed[0]
+	ob.ValueBalance = int64(binary.LittleEndian.Uint64(fixed[1:9]))
+	ob.Anchor = fixed[9:]
 
-	scriptLen, err := readVarint(r)
+	ob.Proof, err = r.bytes("orchard/proof")
 	if err != nil {
 		return nil, err
 	}
 
-	// Read Script
-	script := make([]byte, scriptLen)
-	_, err = io.ReadFull(r, script)
+	for _, a := range ob.Actions {
+		a.SpendAuthSig, err = r.buf("orchard/spendAuthSig", 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ob.BindingSig, err = r.buf("orchard/bindingSig", 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ob, nil
+}
+
+func readTxIns(r *reader) ([]*TxIn, error) {
+	inCtr, err := r.count("inputs", minTxInSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*TxIn
+	for i := 0; i < inCtr; i++ {
+		txin, err := parseTxIn(r)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, txin)
+	}
+	return out, nil
+}
+
+func readTxOuts(r *reader) ([]*TxOut, error) {
+	outCtr, err := r.count("outputs", minTxOutSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*TxOut
+	for i := 0; i < outCtr; i++ {
+		txout, err := parseTxOut(r)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, txout)
+	}
+	return out, nil
+}
+
+func parseTxIn(r *reader) (*TxIn, error) {
+	prevTxHash, err := r.buf("inputs/prevTx", 32)
+	if err != nil {
+		return nil, err
+	}
+
+	prevTxIndex, err := r.uint32("inputs/prevTxIndex")
+	if err != nil {
+		return nil, err
+	}
+
+	script, err := r.bytes("inputs/script")
 	if err != nil {
 		return nil, err
 	}
 
-	seqNo := make([]byte, 4)
-	_, err = io.ReadFull(r, seqNo)
+	seqNo, err := r.uint32("inputs/seqNo")
 	if err != nil {
 		return nil, err
 	}
 
-	var ptxl *cid.Cid
+	var ptxl cid.Cid
 	if !isBlank(prevTxHash) {
 		ptxl = hashToCid(prevTxHash, cid.ZcashTx)
 	}
 	return &TxIn{
 		PrevTx:      ptxl,
-		PrevTxIndex: binary.LittleEndian.Uint32(prevTxIndex),
+		PrevTxIndex: prevTxIndex,
 		Script:      script,
-		SeqNo:       binary.LittleEndian.Uint32(seqNo),
+		SeqNo:       seqNo,
 	}, nil
 }
 
@@ -313,81 +785,103 @@ func isBlank(b []byte) bool {
 	return true
 }
 
-func parseTxOut(r *bytes.Reader) (*TxOut, error) {
-	value := make([]byte, 8)
-	_, err := io.ReadFull(r, value)
+func parseTxOut(r *reader) (*TxOut, error) {
+	value, err := r.uint64("outputs/value")
 	if err != nil {
 		return nil, err
 	}
 
-	scriptLen, err := readVarint(r)
+	script, err := r.bytes("outputs/script")
 	if err != nil {
 		return nil, err
 	}
 
-	script := make([]byte, scriptLen)
-	_, err = io.ReadFull(r, script)
+	return &TxOut{
+		Value:  value,
+		Script: script,
+	}, nil
+}
+
+func readSpendDescription(r *reader) (*SpendDescription, error) {
+	buf, err := r.buf("shieldedSpend", spendV4Size)
 	if err != nil {
 		return nil, err
 	}
 
-	// read script
-	return &TxOut{
-		Value:  binary.LittleEndian.Uint64(value),
-		Script: script,
+	return &SpendDescription{
+		Cv:           buf[:32],
+		Anchor:       buf[32:64],
+		Nullifier:    buf[64:96],
+		Rk:           buf[96:128],
+		Proof:        buf[128:320],
+		SpendAuthSig: buf[320:],
 	}, nil
 }
 
-func readJoinSplit(r *bytes.Reader) (*JSDescription, error) {
-	val := make([]byte, 8)
-	_, err := io.ReadFull(r, val)
+func readOutputDescription(r *reader) (*OutputDescription, error) {
+	buf, err := r.buf("shieldedOutput", outputV4Size)
 	if err != nil {
 		return nil, err
 	}
-	vpub_old := binary.LittleEndian.Uint64(val)
 
-	_, err = io.ReadFull(r, val)
+	return &OutputDescription{
+		Cv:            buf[:32],
+		Cmu:           buf[32:64],
+		EphemeralKey:  buf[64:96],
+		EncCiphertext: buf[96:676],
+		OutCiphertext: buf[676:756],
+		Proof:         buf[756:],
+	}, nil
+}
+
+func readJoinSplit(r *reader, system string) (*JSDescription, error) {
+	vpub_old, err := r.uint64("joinSplit/oldVal")
+	if err != nil {
+		return nil, err
+	}
+
+	vpub_new, err := r.uint64("joinSplit/newVal")
 	if err != nil {
 		return nil, err
 	}
-	vpub_new := binary.LittleEndian.Uint64(val)
 
-	anchor, err := readBuf(r, 32)
+	anchor, err := r.buf("joinSplit/anchor", 32)
 	if err != nil {
 		return nil, err
 	}
 
-	nullifiers, err := readBuf(r, 64)
+	nullifiers, err := r.buf("joinSplit/nullifiers", 64)
 	if err != nil {
 		return nil, err
 	}
 
-	commitments, err := readBuf(r, 64)
+	commitments, err := r.buf("joinSplit/commitments", 64)
 	if err != nil {
 		return nil, err
 	}
 
-	ephKey, err := readBuf(r, 32)
+	ephKey, err := r.buf("joinSplit/ephemeralKey", 32)
 	if err != nil {
 		return nil, err
 	}
 
-	randSeed, err := readBuf(r, 32)
+	randSeed, err := r.buf("joinSplit/randomSeed", 32)
 	if err != nil {
 		return nil, err
 	}
 
-	vmacs, err := readBuf(r, 64)
+	vmacs, err := r.buf("joinSplit/macs", 64)
 	if err != nil {
 		return nil, err
 	}
 
-	zkproof, err := readBuf(r, 296)
+	// sapling era joinsplits carry groth16 proofs instead of PHGR13 ones
+	zkproof, err := r.buf("joinSplit/proof", jsProofSize(system))
 	if err != nil {
 		return nil, err
 	}
 
-	encCiphertexts, err := readBuf(r, 1202)
+	encCiphertexts, err := r.buf("joinSplit/cipherTexts", jsCipherTextsSize)
 	if err != nil {
 		return nil, err
 	}
@@ -402,6 +896,7 @@ func readJoinSplit(r *bytes.Reader) (*JSDescription, error) {
 		EphemeralKey: ephKey,
 		RandomSeed:   randSeed,
 		Proof:        zkproof,
+		ProofSystem:  system,
 		Nullifiers:   [][]byte{nullifiers[:32], nullifiers[32:]},
 	}, nil
 }
@@ -412,39 +907,40 @@ func readBuf(r io.Reader, size int) ([]byte, error) {
 	return out, err
 }
 
-func readVarint(r *bytes.Reader) (int, error) {
+// readVarint reads a CompactSize integer, rejecting encodings that are not
+// the shortest possible for their value as consensus does.
+func readVarint(r byteReader) (uint64, error) {
 	b, err := r.ReadByte()
 	if err != nil {
 		return 0, err
 	}
 
+	var size int
+	var min uint64
 	switch b {
 	case 0xfd:
-		buf := make([]byte, 2)
-		_, err := r.Read(buf)
-		if err != nil {
-			return 0, err
-		}
-		return int(binary.LittleEndian.Uint16(buf)), nil
+		size, min = 2, 0xfd
 	case 0xfe:
-		buf := make([]byte, 4)
-		_, err := r.Read(buf)
-		if err != nil {
-			return 0, err
-		}
-
-		return int(binary.LittleEndian.Uint32(buf)), nil
+		size, min = 4, 0x10000
 	case 0xff:
-		buf := make([]byte, 8)
-		_, err := r.Read(buf)
-		if err != nil {
-			return 0, err
+		size, min = 8, 0x100000000
+	default:
+		return uint64(b), nil
+	}
+
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(r, buf[:size]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
 		}
+		return 0, err
+	}
 
-		return int(binary.LittleEndian.Uint64(buf)), nil
-	default:
-		return int(b), nil
+	n := binary.LittleEndian.Uint64(buf)
+	if n < min {
+		return 0, ErrNonCanonicalVarint
 	}
+	return n, nil
 }
 
 func writeVarInt(w io.Writer, n uint64) error {
@@ -455,14 +951,14 @@ func writeVarInt(w io.Writer, n uint64) error {
 		d = make([]byte, 3)
 		binary.LittleEndian.PutUint16(d[1:], uint16(n))
 		d[0] = 0xFD
-	} else if n <= 0xFFFFFFF {
+	} else if n <= 0xFFFFFFFF {
 		d = make([]byte, 5)
 		binary.LittleEndian.PutUint32(d[1:], uint32(n))
 		d[0] = 0xFE
 	} else {
 		d = make([]byte, 9)
 		binary.LittleEndian.PutUint64(d[1:], n)
-		d[0] = 0xFE
+		d[0] = 0xFF
 	}
 	_, err := w.Write(d)
 	return err
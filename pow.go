@@ -0,0 +1,166 @@
+package ipldzec
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	cid "github.com/ipfs/go-cid"
+	node "github.com/ipfs/go-ipld-format"
+)
+
+// CompactToBig expands a compact nBits value into the target it encodes.
+// Negative targets, which are never valid, are returned as negative numbers.
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	negative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var bn *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		bn = big.NewInt(int64(mantissa))
+	} else {
+		bn = big.NewInt(int64(mantissa))
+		bn.Lsh(bn, 8*(exponent-3))
+	}
+
+	if negative {
+		bn = bn.Neg(bn)
+	}
+	return bn
+}
+
+// BigToCompact converts a target into its compact nBits representation.
+func BigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(n.Bits()[0])
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		tn := new(big.Int).Abs(n)
+		mantissa = uint32(tn.Rsh(tn, 8*(exponent-3)).Bits()[0])
+	}
+
+	// the mantissa is signed, so keep its high bit clear
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x00800000
+	}
+	return compact
+}
+
+// hashToBig interprets a hash in internal byte order as a little endian
+// 256 bit number, the way targets are compared against it.
+func hashToBig(h []byte) *big.Int {
+	return new(big.Int).SetBytes(revString(h))
+}
+
+// Target returns the target the block's hash must not exceed.
+func (b *Block) Target() *big.Int {
+	return CompactToBig(b.Difficulty)
+}
+
+// CheckProofOfWork checks that the block's target is within the network's
+// proof of work limit and that its hash meets that target. The Equihash
+// solution is checked separately by VerifySolution. A nil params uses the
+// block's own Params.
+func (b *Block) CheckProofOfWork(params *Params) error {
+	if params == nil {
+		params = b.params()
+	}
+
+	target := b.Target()
+	if target.Sign() <= 0 {
+		return fmt.Errorf("block target is not positive")
+	}
+
+	if target.Cmp(params.PowLimit) > 0 {
+		return fmt.Errorf("block target exceeds the %s proof of work limit", params.Name)
+	}
+
+	if hashToBig(b.ZecSha()).Cmp(target) > 0 {
+		return fmt.Errorf("block hash %s does not meet its target", b.HexHash())
+	}
+
+	return nil
+}
+
+var oneLsh256 = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// Work returns the expected number of hashes needed to find a block meeting
+// this block's target, 2^256 / (target + 1).
+func (b *Block) Work() *big.Int {
+	target := b.Target()
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+
+	denom := new(big.Int).Add(target, big.NewInt(1))
+	return denom.Div(oneLsh256, denom)
+}
+
+// ChainWork sums the work of the block at tip and its ancestors, following
+// parent links until it reaches the block stop, whose cumulative chain work
+// is given as stopWork. A nil stopWork counts only the work above stop. A
+// stop of cid.Undef walks all the way back to genesis.
+func ChainWork(ctx context.Context, ng node.NodeGetter, tip, stop cid.Cid, stopWork *big.Int) (*big.Int, error) {
+	total := new(big.Int)
+	if stop.Defined() && stopWork != nil {
+		total.Set(stopWork)
+	}
+
+	c := tip
+	for {
+		if stop.Defined() && c.Equals(stop) {
+			return total, nil
+		}
+
+		blk, err := getBlock(ctx, ng, c)
+		if err != nil {
+			return nil, err
+		}
+
+		total.Add(total, blk.Work())
+
+		if !blk.Parent.Defined() || isBlank(cidToHash(blk.Parent)) {
+			if stop.Defined() {
+				return nil, fmt.Errorf("reached genesis without passing %s", stop)
+			}
+			return total, nil
+		}
+		c = blk.Parent
+	}
+}
+
+// getBlock fetches a block header, decoding it if the getter did not, and
+// checks that it is the block c names.
+func getBlock(ctx context.Context, ng node.NodeGetter, c cid.Cid) (*Block, error) {
+	nd, err := ng.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	blk, ok := nd.(*Block)
+	if !ok {
+		blk, err = DecodeBlock(nd.RawData())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !blk.Cid().Equals(c) {
+		return nil, fmt.Errorf("node fetched for %s is block %s", c, blk.Cid())
+	}
+	return blk, nil
+}
@@ -0,0 +1,357 @@
+package ipldzec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	cid "github.com/ipfs/go-cid"
+	node "github.com/ipfs/go-ipld-format"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// MerkleProof proves that a transaction is included in a block. It holds the
+// sibling hashes on the path from the transaction up to the merkle root, and
+// the transaction's position, whose bits select the side of each sibling.
+//
+// As an IPLD node a proof is encoded as a small dag-cbor map with the keys
+// "tx", "index" and "siblings".
+type MerkleProof struct {
+	// Tx is the TxidCid of the proven transaction, since the tree commits
+	// to txids.
+	Tx       cid.Cid
+	Index    uint32
+	Siblings [][]byte
+}
+
+// assert that MerkleProof matches the Node interface for ipld
+var _ node.Node = (*MerkleProof)(nil)
+
+// NewMerkleProof builds the inclusion proof for the transaction at index in
+// a block's ordered transaction list.
+func NewMerkleProof(txs []*Tx, index int) (*MerkleProof, error) {
+	if index < 0 || index >= len(txs) {
+		return nil, fmt.Errorf("index out of range")
+	}
+
+	layer := make([][]byte, len(txs))
+	for i, tx := range txs {
+		layer[i] = tx.ZecSha()
+	}
+
+	return &MerkleProof{
+		Tx:       txs[index].TxidCid(),
+		Index:    uint32(index),
+		Siblings: merkleBranch(layer, index),
+	}, nil
+}
+
+// merkleBranch returns the siblings on the path from the leaf at index up to
+// the root of the merkle tree over layer, leaf first.
+func merkleBranch(layer [][]byte, index int) [][]byte {
+	var siblings [][]byte
+	for len(layer) > 1 {
+		if len(layer)%2 != 0 {
+			layer = append(layer, layer[len(layer)-1])
+		}
+
+		siblings = append(siblings, layer[index^1])
+
+		next := make([][]byte, len(layer)/2)
+		for i := range next {
+			next[i] = dblSha(append(append([]byte{}, layer[2*i]...), layer[2*i+1]...))
+		}
+		layer = next
+		index /= 2
+	}
+	return siblings
+}
+
+// ProveTx builds the inclusion proof for the transaction tx by walking the
+// block's TxTree nodes, fetched through ng a layer at a time. The
+// transactions themselves are not fetched, except for the coinbase when
+// blk.TxCount is unknown (see txTreeDepth).
+//
+// tx may be the transaction's Cid or its TxidCid. The tree links to
+// transactions by txid, so the Cid of a v5 transaction is first mapped to
+// its txid by fetching the transaction.
+func ProveTx(ctx context.Context, ng node.NodeGetter, blk *Block, tx cid.Cid) (*MerkleProof, error) {
+	if !isTxidCid(tx) {
+		var err error
+		tx, err = txidCid(ctx, ng, tx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	leaves, err := txTreeLeaves(ctx, ng, blk)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, c := range leaves {
+		if !c.Equals(tx) {
+			continue
+		}
+
+		hashes := make([][]byte, len(leaves))
+		for j, c := range leaves {
+			hashes[j] = cidToHash(c)
+		}
+
+		return &MerkleProof{
+			Tx:       tx,
+			Index:    uint32(i),
+			Siblings: merkleBranch(hashes, i),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("transaction %s is not in block %s", tx, blk.Cid())
+}
+
+// txTreeLeaves fetches the TxTree layers of blk and returns the links to its
+// transactions in block order.
+func txTreeLeaves(ctx context.Context, ng node.NodeGetter, blk *Block) ([]cid.Cid, error) {
+	depth, err := txTreeDepth(ctx, ng, blk)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := DecodeOptions{Params: blk.params(), TxNodeKind: TxTreeNode}
+	layer := []cid.Cid{blk.MerkleRoot}
+	for ; depth > 0; depth-- {
+		nds, err := getLayer(ctx, ng, layer, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		var next []cid.Cid
+		for _, nd := range nds {
+			tree, ok := nd.(*TxTree)
+			if !ok {
+				return nil, fmt.Errorf("transaction tree of block %s is unbalanced", blk.Cid())
+			}
+			next = append(next, tree.Left.Cid)
+
+			// the last node of a layer with an odd count is paired with
+			// itself
+			if !tree.Right.Cid.Equals(tree.Left.Cid) {
+				next = append(next, tree.Right.Cid)
+			}
+		}
+		layer = next
+	}
+
+	return layer, nil
+}
+
+// Root computes the merkle root implied by the proof.
+func (p *MerkleProof) Root() []byte {
+	root, _ := p.root()
+	return root
+}
+
+// root computes the merkle root implied by the proof, and whether the path
+// passes through the copy that pads a layer with an odd count. A right child
+// equal to its sibling is always such a copy, since a block containing the
+// same subtree twice is rejected as mutated.
+func (p *MerkleProof) root() ([]byte, bool) {
+	var padding bool
+	h := cidToHash(p.Tx)
+	for i, sib := range p.Siblings {
+		if p.Index>>uint(i)&1 == 1 {
+			padding = padding || bytes.Equal(sib, h)
+			h = dblSha(append(append([]byte{}, sib...), h...))
+		} else {
+			h = dblSha(append(append([]byte{}, h...), sib...))
+		}
+	}
+	return h, padding
+}
+
+// Verify checks the proof against the merkle root of a block header. The
+// index must be that of one of the block's transactions, not of the padding
+// that completes the tree, which would give the same root.
+func (p *MerkleProof) Verify(blk *Block) error {
+	if len(p.Siblings) < 32 && p.Index>>uint(len(p.Siblings)) != 0 {
+		return fmt.Errorf("proof index %d out of range for a tree of depth %d", p.Index, len(p.Siblings))
+	}
+
+	if blk.TxCount != 0 && p.Index >= blk.TxCount {
+		return fmt.Errorf("proof index %d out of range for a block of %d transactions", p.Index, blk.TxCount)
+	}
+
+	header := cidToHash(blk.MerkleRoot)
+	root, padding := p.root()
+	if padding {
+		return fmt.Errorf("proof index %d is past the block's last transaction", p.Index)
+	}
+	if !bytes.Equal(root, header) {
+		return &MerkleRootError{Header: header, Computed: root}
+	}
+	return nil
+}
+
+func (p *MerkleProof) Cid() cid.Cid {
+	h, _ := mh.Sum(p.RawData(), mh.SHA2_256, -1)
+	return cid.NewCidV1(cid.DagCBOR, h)
+}
+
+func (p *MerkleProof) RawData() []byte {
+	buf := new(bytes.Buffer)
+	cborHead(buf, cborMap, 3)
+
+	cborWriteText(buf, "tx")
+	cborHead(buf, cborTag, cborCidTag)
+	cborHead(buf, cborBytes, uint64(len(p.Tx.Bytes())+1))
+	buf.WriteByte(0)
+	buf.Write(p.Tx.Bytes())
+
+	cborWriteText(buf, "index")
+	cborHead(buf, cborUint, uint64(p.Index))
+
+	cborWriteText(buf, "siblings")
+	cborHead(buf, cborArray, uint64(len(p.Siblings)))
+	for _, sib := range p.Siblings {
+		cborHead(buf, cborBytes, uint64(len(sib)))
+		buf.Write(sib)
+	}
+
+	return buf.Bytes()
+}
+
+// DecodeMerkleProof parses a proof from its dag-cbor encoding.
+func DecodeMerkleProof(b []byte) (*MerkleProof, error) {
+	r := &cborReader{r: bytes.NewReader(b)}
+	if err := r.expect(cborMap, 3); err != nil {
+		return nil, err
+	}
+
+	var p MerkleProof
+	if err := r.expectText("tx"); err != nil {
+		return nil, err
+	}
+	if err := r.expect(cborTag, cborCidTag); err != nil {
+		return nil, err
+	}
+	raw, err := r.bytes()
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 || raw[0] != 0 {
+		return nil, fmt.Errorf("invalid cid link in merkle proof")
+	}
+	p.Tx, err = cid.Cast(raw[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.expectText("index"); err != nil {
+		return nil, err
+	}
+	index, err := r.head(cborUint)
+	if err != nil {
+		return nil, err
+	}
+	if index > 0xffffffff {
+		return nil, fmt.Errorf("merkle proof index out of range")
+	}
+	p.Index = uint32(index)
+
+	if err := r.expectText("siblings"); err != nil {
+		return nil, err
+	}
+	n, err := r.head(cborArray)
+	if err != nil {
+		return nil, err
+	}
+	if n > 32 {
+		return nil, fmt.Errorf("merkle proof too deep")
+	}
+	for i := uint64(0); i < n; i++ {
+		sib, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		if len(sib) != 32 {
+			return nil, fmt.Errorf("merkle proof sibling is %d bytes", len(sib))
+		}
+		p.Siblings = append(p.Siblings, sib)
+	}
+
+	if r.r.Len() != 0 {
+		return nil, fmt.Errorf("trailing data after merkle proof")
+	}
+
+	return &p, nil
+}
+
+func (p *MerkleProof) Links() []*node.Link {
+	return []*node.Link{{Name: "tx", Cid: p.Tx}}
+}
+
+func (p *MerkleProof) Loggable() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "zcash_merkle_proof",
+	}
+}
+
+func (p *MerkleProof) Resolve(path []string) (interface{}, []string, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("zero length path")
+	}
+
+	switch path[0] {
+	case "tx":
+		return &node.Link{Cid: p.Tx}, path[1:], nil
+	case "index":
+		return p.Index, path[1:], nil
+	case "siblings":
+		if len(path) == 1 {
+			return p.Siblings, nil, nil
+		}
+
+		index, err := parseIndex(path[1], len(p.Siblings))
+		if err != nil {
+			return nil, nil, err
+		}
+		return p.Siblings[index], path[2:], nil
+	default:
+		return nil, nil, fmt.Errorf("no such link")
+	}
+}
+
+func (p *MerkleProof) ResolveLink(path []string) (*node.Link, []string, error) {
+	out, rest, err := p.Resolve(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lnk, ok := out.(*node.Link)
+	if !ok {
+		return nil, nil, fmt.Errorf("object at path was not a link")
+	}
+
+	return lnk, rest, nil
+}
+
+func (p *MerkleProof) Copy() node.Node {
+	np := *p
+	return &np
+}
+
+func (p *MerkleProof) Size() (uint64, error) {
+	return uint64(len(p.RawData())), nil
+}
+
+func (p *MerkleProof) Stat() (*node.NodeStat, error) {
+	return &node.NodeStat{}, nil
+}
+
+func (p *MerkleProof) String() string {
+	return fmt.Sprintf("[zcash merkle proof]")
+}
+
+func (p *MerkleProof) Tree(path string, depth int) []string {
+	return []string{"tx", "index", "siblings"}
+}
@@ -0,0 +1,47 @@
+package ipldzec
+
+import (
+	"fmt"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	node "github.com/ipfs/go-ipld-format"
+)
+
+// Importing this package registers its decoders with go-ipld-format, so that
+// node.Decode handles zcash blocks and transactions.
+func init() {
+	RegisterDecoders(node.DefaultBlockDecoder)
+}
+
+// RegisterDecoders registers DecodeNode for the zcash-block and zcash-tx
+// codecs with d.
+func RegisterDecoders(d node.BlockDecoder) {
+	d.Register(cid.ZcashBlock, DecodeNode)
+	d.Register(cid.ZcashTx, DecodeNode)
+}
+
+// DecodeNode decodes a zcash block header, transaction or transaction tree
+// node according to the codec of blk's CID, and checks that the decoded node
+// has that CID.
+func DecodeNode(blk blocks.Block) (node.Node, error) {
+	return DecodeNodeWithOptions(blk, DecodeOptions{})
+}
+
+// DecodeNodeWithOptions is DecodeNode with options. Decoding is always
+// Strict, so that no other encoding of the node is accepted under its CID.
+func DecodeNodeWithOptions(blk blocks.Block, opts DecodeOptions) (node.Node, error) {
+	opts.Strict = true
+
+	c := blk.Cid()
+	nd, err := decodeNode(c, blk.RawData(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if !nd.Cid().Equals(c) {
+		return nil, fmt.Errorf("data for %s decodes to a node with cid %s", c, nd.Cid())
+	}
+
+	return nd, nil
+}
@@ -0,0 +1,204 @@
+package ipldzec
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	node "github.com/ipfs/go-ipld-format"
+)
+
+// Resolver resolves paths that cross from node to node, such as
+// parent/parent/tx/0/1/outputs/2/value, fetching each linked node through a
+// NodeGetter.
+type Resolver struct {
+	Getter node.NodeGetter
+
+	// Options are used to decode nodes the getter returns undecoded.
+	Options DecodeOptions
+}
+
+func NewResolver(ng node.NodeGetter) *Resolver {
+	return &Resolver{Getter: ng}
+}
+
+// Resolve resolves the slash separated path starting from the node root. It
+// returns the value at the end of the path, and the CIDs of the nodes it
+// visited in order, starting with root. A path ending at a link returns the
+// *node.Link without fetching the node it points to.
+//
+// Links to v5 transactions by txid are followed by looking the txid up, if
+// the getter implements TxLookup, and the transaction's own CID is recorded
+// as visited.
+//
+// A block fetched on its own records neither its transaction count nor its
+// height. Resolving txs paths or txCount counts the transactions through
+// the TxTree, and resolving the height or a commitment field reads the
+// height from the coinbase.
+//
+// 64 byte zcash-tx nodes are decoded as whatever the link leading to them
+// says they are: prevouts point at transactions, and below a block with a
+// known TxCount the depth in the TxTree tells. Elsewhere Options.TxNodeKind
+// applies.
+func (r *Resolver) Resolve(ctx context.Context, root cid.Cid, path string) (interface{}, []cid.Cid, error) {
+	var parts []string
+	if p := strings.Trim(path, "/"); p != "" {
+		parts = strings.Split(p, "/")
+	}
+
+	visited := []cid.Cid{root}
+	nd, err := r.get(ctx, root, r.Options)
+	if err != nil {
+		return nil, visited, err
+	}
+	visited[0] = nd.Cid()
+
+	// the number of TxTree layers from nd down to the transactions, or -1
+	depth := -1
+	for len(parts) > 0 {
+		if blk, ok := nd.(*Block); ok {
+			nd, err = r.completeBlock(ctx, blk, parts[0])
+			if err != nil {
+				return nil, visited, err
+			}
+		}
+
+		val, rest, err := nd.Resolve(parts)
+		if err != nil {
+			return nil, visited, err
+		}
+
+		if len(rest) == 0 {
+			return val, visited, nil
+		}
+
+		lnk, ok := val.(*node.Link)
+		if !ok {
+			return nil, visited, fmt.Errorf("cannot resolve %s below a %T", strings.Join(rest, "/"), val)
+		}
+
+		opts := r.Options
+		opts.TxNodeKind, depth = linkKind(nd, lnk, depth)
+		if opts.TxNodeKind == GuessTxNode {
+			opts.TxNodeKind = r.Options.TxNodeKind
+		}
+
+		visited = append(visited, lnk.Cid)
+		nd, err = r.get(ctx, lnk.Cid, opts)
+		if err != nil {
+			return nil, visited, err
+		}
+		visited[len(visited)-1] = nd.Cid()
+		parts = rest
+	}
+
+	return nd, visited, nil
+}
+
+// completeBlock fills in what resolving field on blk needs and a block
+// fetched on its own doesn't have: the transaction count for txs paths and
+// txCount, and the height, read from the coinbase, for the height and
+// commitment fields. The getter may share blk, so a copy is filled in.
+func (r *Resolver) completeBlock(ctx context.Context, blk *Block, field string) (*Block, error) {
+	switch field {
+	case "txs", "txCount":
+		if blk.TxCount != 0 {
+			return blk, nil
+		}
+
+		n, err := txCount(ctx, r.Getter, blk)
+		if err != nil {
+			return nil, err
+		}
+
+		counted := *blk
+		counted.TxCount = n
+		return &counted, nil
+	case "height", FinalSaplingRootField, LightClientRootField, BlockCommitmentsField:
+		if _, ok := blk.height(); ok {
+			return blk, nil
+		}
+
+		height, err := blockHeight(ctx, r.Getter, blk)
+		if err != nil {
+			return nil, err
+		}
+
+		full := *blk
+		full.Height = height
+		return &full, nil
+	default:
+		return blk, nil
+	}
+}
+
+// get fetches the node c, decoding it if it is a zcash node the getter
+// returned undecoded, and checks that it hashes to c. Nodes of other codecs
+// are returned as the getter decoded them. A txid CID the getter doesn't have
+// is looked up instead, see TxLookup.
+func (r *Resolver) get(ctx context.Context, c cid.Cid, opts DecodeOptions) (node.Node, error) {
+	nd, err := getOrLookup(ctx, r.Getter, c, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.Type() {
+	case cid.ZcashBlock:
+		if _, ok := nd.(*Block); !ok {
+			nd, err = DecodeBlockWithOptions(nd.RawData(), opts)
+		}
+	case cid.ZcashTx:
+		nd, err = asTxNode(nd, opts)
+	default:
+		return nd, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasCid(nd, c) {
+		return nil, fmt.Errorf("node fetched for %s has cid %s", c, nd.Cid())
+	}
+
+	return nd, nil
+}
+
+// linkKind tells which kind of zcash-tx node lnk, followed from nd, points
+// to, along with its depth in the TxTree (see Resolve). It returns
+// GuessTxNode and -1 when the context doesn't say.
+func linkKind(nd node.Node, lnk *node.Link, depth int) (TxNodeKind, int) {
+	switch nd := nd.(type) {
+	case *Block:
+		if nd.TxCount == 0 || !lnk.Cid.Equals(nd.MerkleRoot) {
+			return GuessTxNode, -1
+		}
+		depth = treeDepth(nd.TxCount)
+	case *TxTree:
+		if depth < 1 {
+			return GuessTxNode, -1
+		}
+		depth--
+	case *Tx:
+		return TxNode, -1
+	default:
+		return GuessTxNode, -1
+	}
+
+	if depth == 0 {
+		return TxNode, 0
+	}
+	return TxTreeNode, depth
+}
+
+// decodeNode decodes raw with the codec of c.
+func decodeNode(c cid.Cid, raw []byte, opts DecodeOptions) (node.Node, error) {
+	switch c.Type() {
+	case cid.ZcashBlock:
+		return DecodeBlockWithOptions(raw, opts)
+	case cid.ZcashTx:
+		return DecodeMaybeTxWithOptions(raw, opts)
+	default:
+		return nil, fmt.Errorf("unsupported codec %x for %s", c.Type(), c)
+	}
+}
@@ -0,0 +1,80 @@
+package ipldzec
+
+import (
+	"fmt"
+	"io"
+)
+
+// SpendDescription is a Sapling shielded spend as found in v4 transactions.
+type SpendDescription struct {
+	Cv           []byte `json:"cv"`
+	Anchor       []byte `json:"anchor"`
+	Nullifier    []byte `json:"nullifier"`
+	Rk           []byte `json:"rk"`
+	Proof        []byte `json:"proof"`
+	SpendAuthSig []byte `json:"spendAuthSig"`
+}
+
+func (sd *SpendDescription) WriteTo(w io.Writer) (int, error) {
+	return writeMany(w, sd.Cv, sd.Anchor, sd.Nullifier, sd.Rk, sd.Proof, sd.SpendAuthSig)
+}
+
+func (sd *SpendDescription) Resolve(path []string) (interface{}, []string, error) {
+	if len(path) == 0 {
+		return sd, nil, nil
+	}
+
+	switch path[0] {
+	case "cv":
+		return sd.Cv, path[1:], nil
+	case "anchor":
+		return sd.Anchor, path[1:], nil
+	case "nullifier":
+		return sd.Nullifier, path[1:], nil
+	case "rk":
+		return sd.Rk, path[1:], nil
+	case "proof":
+		return sd.Proof, path[1:], nil
+	case "spendAuthSig":
+		return sd.SpendAuthSig, path[1:], nil
+	default:
+		return nil, nil, fmt.Errorf("no such link")
+	}
+}
+
+// OutputDescription is a Sapling shielded output as found in v4 transactions.
+type OutputDescription struct {
+	Cv            []byte `json:"cv"`
+	Cmu           []byte `json:"cmu"`
+	EphemeralKey  []byte `json:"ephemeralKey"`
+	EncCiphertext []byte `json:"encCiphertext"`
+	OutCiphertext []byte `json:"outCiphertext"`
+	Proof         []byte `json:"proof"`
+}
+
+func (od *OutputDescription) WriteTo(w io.Writer) (int, error) {
+	return writeMany(w, od.Cv, od.Cmu, od.EphemeralKey, od.EncCiphertext, od.OutCiphertext, od.Proof)
+}
+
+func (od *OutputDescription) Resolve(path []string) (interface{}, []string, error) {
+	if len(path) == 0 {
+		return od, nil, nil
+	}
+
+	switch path[0] {
+	case "cv":
+		return od.Cv, path[1:], nil
+	case "cmu":
+		return od.Cmu, path[1:], nil
+	case "ephemeralKey":
+		return od.EphemeralKey, path[1:], nil
+	case "encCiphertext":
+		return od.EncCiphertext, path[1:], nil
+	case "outCiphertext":
+		return od.OutCiphertext, path[1:], nil
+	case "proof":
+		return od.Proof, path[1:], nil
+	default:
+		return nil, nil, fmt.Errorf("no such link")
+	}
+}
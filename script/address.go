@@ -0,0 +1,96 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+// Network holds the two byte Base58Check version prefixes of transparent
+// addresses on a Zcash network.
+type Network struct {
+	Name             string
+	PubKeyHashPrefix [2]byte
+	ScriptHashPrefix [2]byte
+}
+
+var (
+	// MainNet addresses start with t1 (P2PKH) and t3 (P2SH).
+	MainNet = &Network{
+		Name:             "mainnet",
+		PubKeyHashPrefix: [2]byte{0x1c, 0xb8},
+		ScriptHashPrefix: [2]byte{0x1c, 0xbd},
+	}
+
+	// TestNet addresses start with tm (P2PKH) and t2 (P2SH).
+	TestNet = &Network{
+		Name:             "testnet",
+		PubKeyHashPrefix: [2]byte{0x1d, 0x25},
+		ScriptHashPrefix: [2]byte{0x1c, 0xba},
+	}
+)
+
+// Address returns the transparent address paid to by a P2PKH or P2SH output
+// script on the given network. A nil net means MainNet.
+func Address(script []byte, net *Network) (string, error) {
+	if net == nil {
+		net = MainNet
+	}
+
+	class, data := classify(script)
+
+	var prefix [2]byte
+	switch class {
+	case PubKeyHash:
+		prefix = net.PubKeyHashPrefix
+	case ScriptHash:
+		prefix = net.ScriptHashPrefix
+	default:
+		return "", fmt.Errorf("%s script has no address", class)
+	}
+
+	payload := append(prefix[:], data[0]...)
+	return b58Encode(append(payload, checksum(payload)...)), nil
+}
+
+// AddressScript returns the output script paying to a transparent address on
+// the given network. A nil net means MainNet.
+func AddressScript(addr string, net *Network) ([]byte, error) {
+	if net == nil {
+		net = MainNet
+	}
+
+	raw, err := b58Decode(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(raw) != 26 {
+		return nil, fmt.Errorf("invalid address length")
+	}
+
+	payload := raw[:22]
+	if !bytes.Equal(checksum(payload), raw[22:]) {
+		return nil, fmt.Errorf("invalid address checksum")
+	}
+
+	hash := payload[2:]
+	switch {
+	case bytes.Equal(payload[:2], net.PubKeyHashPrefix[:]):
+		out := []byte{OP_DUP, OP_HASH160, 20}
+		out = append(out, hash...)
+		return append(out, OP_EQUALVERIFY, OP_CHECKSIG), nil
+	case bytes.Equal(payload[:2], net.ScriptHashPrefix[:]):
+		out := []byte{OP_HASH160, 20}
+		out = append(out, hash...)
+		return append(out, OP_EQUAL), nil
+	default:
+		return nil, fmt.Errorf("address is not a %s transparent address", net.Name)
+	}
+}
+
+func checksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	return second[:4]
+}
@@ -0,0 +1,55 @@
+package script
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const b58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var b58Radix = big.NewInt(58)
+
+// b58Encode encodes b in Base58, with a leading '1' for each leading zero
+// byte.
+func b58Encode(b []byte) string {
+	x := new(big.Int).SetBytes(b)
+	mod := new(big.Int)
+
+	var out []byte
+	for x.Sign() > 0 {
+		x.DivMod(x, b58Radix, mod)
+		out = append(out, b58Alphabet[mod.Int64()])
+	}
+
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		out = append(out, b58Alphabet[0])
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+// b58Decode is the inverse of b58Encode.
+func b58Decode(s string) ([]byte, error) {
+	x := new(big.Int)
+	for i := 0; i < len(s); i++ {
+		d := strings.IndexByte(b58Alphabet, s[i])
+		if d < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", s[i])
+		}
+		x.Mul(x, b58Radix)
+		x.Add(x, big.NewInt(int64(d)))
+	}
+
+	var zeros int
+	for zeros < len(s) && s[zeros] == b58Alphabet[0] {
+		zeros++
+	}
+	return append(make([]byte, zeros), x.Bytes()...), nil
+}
@@ -0,0 +1,270 @@
+// Package script parses and classifies Zcash transparent scripts.
+package script
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Class is the standard template a script matches.
+type Class string
+
+const (
+	NonStandard Class = "nonstandard"
+	PubKey      Class = "pubkey"
+	PubKeyHash  Class = "pubkeyhash"
+	ScriptHash  Class = "scripthash"
+	MultiSig    Class = "multisig"
+	NullData    Class = "nulldata"
+	Coinbase    Class = "coinbase"
+)
+
+// Op is a single parsed script operation. Data is set for push operations.
+type Op struct {
+	Code byte
+	Data []byte
+}
+
+// IsPush reports whether the op pushes data (or a small integer) onto the
+// stack.
+func (o Op) IsPush() bool {
+	return o.Code <= OP_16 && o.Code != 0x50
+}
+
+// Parse splits a script into its operations. It fails if a push runs past the
+// end of the script, returning the ops parsed up to that point.
+func Parse(script []byte) ([]Op, error) {
+	var ops []Op
+	for i := 0; i < len(script); {
+		code := script[i]
+		i++
+
+		var n int
+		switch {
+		case code < OP_PUSHDATA1:
+			n = int(code)
+		case code == OP_PUSHDATA1:
+			if i+1 > len(script) {
+				return ops, fmt.Errorf("truncated OP_PUSHDATA1")
+			}
+			n = int(script[i])
+			i++
+		case code == OP_PUSHDATA2:
+			if i+2 > len(script) {
+				return ops, fmt.Errorf("truncated OP_PUSHDATA2")
+			}
+			n = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		case code == OP_PUSHDATA4:
+			if i+4 > len(script) {
+				return ops, fmt.Errorf("truncated OP_PUSHDATA4")
+			}
+			n = int(binary.LittleEndian.Uint32(script[i:]))
+			i += 4
+		default:
+			ops = append(ops, Op{Code: code})
+			continue
+		}
+
+		if n < 0 || i+n > len(script) {
+			return ops, fmt.Errorf("push of %d bytes past end of script", n)
+		}
+
+		ops = append(ops, Op{Code: code, Data: script[i : i+n]})
+		i += n
+	}
+	return ops, nil
+}
+
+// Disassemble renders a script in the ASM form used by zcashd: data pushes as
+// hex, small integers as numbers and everything else by opcode name. An
+// unparseable tail is shown as [error].
+func Disassemble(script []byte) string {
+	ops, err := Parse(script)
+
+	parts := make([]string, 0, len(ops)+1)
+	for _, op := range ops {
+		parts = append(parts, op.String())
+	}
+	if err != nil {
+		parts = append(parts, "[error]")
+	}
+	return strings.Join(parts, " ")
+}
+
+func (o Op) String() string {
+	switch {
+	case o.Code == OP_0:
+		return "0"
+	case o.Code <= OP_PUSHDATA4:
+		return hex.EncodeToString(o.Data)
+	case o.Code >= OP_1 && o.Code <= OP_16:
+		return fmt.Sprint(int(o.Code-OP_1) + 1)
+	}
+
+	if name, ok := opcodeNames[o.Code]; ok {
+		return name
+	}
+	return fmt.Sprintf("OP_UNKNOWN(0x%02x)", o.Code)
+}
+
+// Classify returns the standard template matched by an output script.
+func Classify(script []byte) Class {
+	class, _ := classify(script)
+	return class
+}
+
+// ExtractData returns the payload of a standard script: the key or script
+// hash for pay-to-hash templates, the public keys of a multisig or pay-to-key
+// script, or the pushed data of an OP_RETURN output.
+func ExtractData(script []byte) [][]byte {
+	_, data := classify(script)
+	return data
+}
+
+func classify(script []byte) (Class, [][]byte) {
+	ops, err := Parse(script)
+	if err != nil {
+		return NonStandard, nil
+	}
+
+	switch {
+	case isPubKeyHash(ops):
+		return PubKeyHash, [][]byte{ops[2].Data}
+	case isScriptHash(script):
+		return ScriptHash, [][]byte{script[2:22]}
+	case isPubKey(ops):
+		return PubKey, [][]byte{ops[0].Data}
+	case isMultiSig(ops):
+		var keys [][]byte
+		for _, op := range ops[1 : len(ops)-2] {
+			keys = append(keys, op.Data)
+		}
+		return MultiSig, keys
+	case isNullData(ops):
+		var data [][]byte
+		for _, op := range ops[1:] {
+			data = append(data, op.Data)
+		}
+		return NullData, data
+	default:
+		return NonStandard, nil
+	}
+}
+
+// isPubKeyHash matches OP_DUP OP_HASH160 <20 bytes> OP_EQUALVERIFY OP_CHECKSIG.
+func isPubKeyHash(ops []Op) bool {
+	return len(ops) == 5 &&
+		ops[0].Code == OP_DUP &&
+		ops[1].Code == OP_HASH160 &&
+		ops[2].Code == 20 &&
+		ops[3].Code == OP_EQUALVERIFY &&
+		ops[4].Code == OP_CHECKSIG
+}
+
+// isScriptHash matches the exact byte pattern OP_HASH160 <20 bytes> OP_EQUAL
+// required by BIP16.
+func isScriptHash(script []byte) bool {
+	return len(script) == 23 &&
+		script[0] == OP_HASH160 &&
+		script[1] == 20 &&
+		script[22] == OP_EQUAL
+}
+
+// isPubKey matches <33 or 65 byte key> OP_CHECKSIG.
+func isPubKey(ops []Op) bool {
+	return len(ops) == 2 &&
+		(len(ops[0].Data) == 33 || len(ops[0].Data) == 65) &&
+		ops[0].Code < OP_PUSHDATA1 &&
+		ops[1].Code == OP_CHECKSIG
+}
+
+// isMultiSig matches OP_m <pubkey>... OP_n OP_CHECKMULTISIG.
+func isMultiSig(ops []Op) bool {
+	if len(ops) < 4 || ops[len(ops)-1].Code != OP_CHECKMULTISIG {
+		return false
+	}
+
+	m, n := smallInt(ops[0].Code), smallInt(ops[len(ops)-2].Code)
+	if m < 1 || n < m || n != len(ops)-3 {
+		return false
+	}
+
+	for _, op := range ops[1 : len(ops)-2] {
+		if op.Code >= OP_PUSHDATA1 || (len(op.Data) != 33 && len(op.Data) != 65) {
+			return false
+		}
+	}
+	return true
+}
+
+// isNullData matches OP_RETURN followed only by data pushes.
+func isNullData(ops []Op) bool {
+	if len(ops) == 0 || ops[0].Code != OP_RETURN {
+		return false
+	}
+
+	for _, op := range ops[1:] {
+		if !op.IsPush() {
+			return false
+		}
+	}
+	return true
+}
+
+func smallInt(code byte) int {
+	if code >= OP_1 && code <= OP_16 {
+		return int(code-OP_1) + 1
+	}
+	return -1
+}
+
+// Info is a summary of a script suitable for exposing through IPLD paths.
+type Info struct {
+	Type Class    `json:"type"`
+	Asm  string   `json:"asm"`
+	Data [][]byte `json:"data,omitempty"`
+}
+
+// Analyze classifies and disassembles a script.
+func Analyze(script []byte) *Info {
+	class, data := classify(script)
+	return &Info{
+		Type: class,
+		Asm:  Disassemble(script),
+		Data: data,
+	}
+}
+
+// Resolve traverses a path through the script summary.
+func (i *Info) Resolve(path []string) (interface{}, []string, error) {
+	if len(path) == 0 {
+		return i, nil, nil
+	}
+
+	switch path[0] {
+	case "type":
+		return string(i.Type), path[1:], nil
+	case "asm":
+		return i.Asm, path[1:], nil
+	case "data":
+		if len(path) == 1 {
+			return i.Data, nil, nil
+		}
+
+		index, err := strconv.Atoi(path[1])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if index < 0 || index >= len(i.Data) {
+			return nil, nil, fmt.Errorf("index out of range")
+		}
+		return i.Data[index], path[2:], nil
+	default:
+		return nil, nil, fmt.Errorf("no such link")
+	}
+}
@@ -0,0 +1,155 @@
+package script
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		script string
+		class  Class
+		asm    string
+	}{
+		{
+			script: "76a914a3b2a8b2f1de71de4cf5e8e2a7b5ec9d0ed0d6d088ac",
+			class:  PubKeyHash,
+			asm:    "OP_DUP OP_HASH160 a3b2a8b2f1de71de4cf5e8e2a7b5ec9d0ed0d6d0 OP_EQUALVERIFY OP_CHECKSIG",
+		},
+		{
+			script: "a9147d46a730d31f97b1930d3368a967c309bd4d136a87",
+			class:  ScriptHash,
+			asm:    "OP_HASH160 7d46a730d31f97b1930d3368a967c309bd4d136a OP_EQUAL",
+		},
+		{
+			script: "51" + "2102" + strings.Repeat("11", 32) + "2103" + strings.Repeat("22", 32) + "52ae",
+			class:  MultiSig,
+		},
+		{
+			script: "6a0568656c6c6f",
+			class:  NullData,
+			asm:    "OP_RETURN 68656c6c6f",
+		},
+		{
+			script: "0051",
+			class:  NonStandard,
+			asm:    "0 1",
+		},
+		{
+			script: "4c05aabb",
+			class:  NonStandard,
+			asm:    "[error]",
+		},
+	}
+
+	for i, c := range cases {
+		s := mustHex(c.script)
+		if got := Classify(s); got != c.class {
+			t.Errorf("case %d: expected class %s, got %s", i, c.class, got)
+		}
+		if c.asm != "" && Disassemble(s) != c.asm {
+			t.Errorf("case %d: got asm %q", i, Disassemble(s))
+		}
+	}
+}
+
+func TestExtractData(t *testing.T) {
+	data := ExtractData(mustHex("76a914a3b2a8b2f1de71de4cf5e8e2a7b5ec9d0ed0d6d088ac"))
+	if len(data) != 1 || hex.EncodeToString(data[0]) != "a3b2a8b2f1de71de4cf5e8e2a7b5ec9d0ed0d6d0" {
+		t.Fatal("should extract the pubkey hash")
+	}
+
+	info := Analyze(mustHex("6a0568656c6c6f"))
+	out, _, err := info.Resolve([]string{"data", "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out.([]byte)) != "hello" {
+		t.Fatal("should extract the OP_RETURN payload")
+	}
+}
+
+func TestAddressRoundTrip(t *testing.T) {
+	cases := []struct {
+		script string
+		net    *Network
+		prefix string
+	}{
+		{"76a914a3b2a8b2f1de71de4cf5e8e2a7b5ec9d0ed0d6d088ac", MainNet, "t1"},
+		{"a9147d46a730d31f97b1930d3368a967c309bd4d136a87", MainNet, "t3"},
+		{"76a914a3b2a8b2f1de71de4cf5e8e2a7b5ec9d0ed0d6d088ac", TestNet, "tm"},
+		{"a9147d46a730d31f97b1930d3368a967c309bd4d136a87", TestNet, "t2"},
+	}
+
+	for i, c := range cases {
+		addr, err := Address(mustHex(c.script), c.net)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(addr, c.prefix) {
+			t.Errorf("case %d: expected address prefix %s, got %s", i, c.prefix, addr)
+		}
+
+		out, err := AddressScript(addr, c.net)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hex.EncodeToString(out) != c.script {
+			t.Errorf("case %d: address did not map back to its script", i)
+		}
+	}
+
+	// founders reward address from the genesis era
+	addr, _ := Address(mustHex(cases[1].script), MainNet)
+	if addr != "t3Vz22vK5z2LcKEdg16Yv4FFneEL1zg9ojd" {
+		t.Fatalf("got wrong address %s", addr)
+	}
+
+	addr, _ = Address(mustHex(cases[0].script), MainNet)
+	if _, err := AddressScript(addr, TestNet); err == nil {
+		t.Fatal("mainnet address should not decode on testnet")
+	}
+
+	if def, err := Address(mustHex(cases[0].script), nil); err != nil || def != addr {
+		t.Fatal("a nil network should give mainnet addresses")
+	}
+
+	if out, err := AddressScript(addr, nil); err != nil || hex.EncodeToString(out) != cases[0].script {
+		t.Fatal("a nil network should decode mainnet addresses")
+	}
+
+	if _, err := Address(mustHex("6a0568656c6c6f"), MainNet); err == nil {
+		t.Fatal("OP_RETURN outputs have no address")
+	}
+}
+
+func TestBase58(t *testing.T) {
+	in := mustHex("0000287fb4cd")
+	enc := b58Encode(in)
+	if enc != "11233QC4" {
+		t.Fatalf("got %s", enc)
+	}
+
+	out, err := b58Decode(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(out) != "0000287fb4cd" {
+		t.Fatal("leading zeros did not round trip")
+	}
+
+	if _, err := b58Decode("t1O0"); err == nil {
+		t.Fatal("0 and O are not base58 digits")
+	}
+}
@@ -10,28 +10,104 @@ import (
 
 	cid "github.com/ipfs/go-cid"
 	node "github.com/ipfs/go-ipld-format"
+	script "github.com/ipfs/go-ipld-zcash/script"
 	mh "github.com/multiformats/go-multihash"
 )
 
+// Version group IDs that accompany overwintered transaction versions.
+const (
+	OverwinterVersionGroupID = 0x03C48270
+	SaplingVersionGroupID    = 0x892F2085
+	NU5VersionGroupID        = 0x26A7270A
+)
+
 type Tx struct {
-	Version    uint32           `json:"version"`
-	Inputs     []*TxIn          `json:"inputs"`
-	Outputs    []*TxOut         `json:"outputs"`
-	LockTime   uint32           `json:"locktime"`
+	Overwintered      bool     `json:"overwintered,omitempty"`
+	Version           uint32   `json:"version"`
+	VersionGroupID    uint32   `json:"versionGroupId,omitempty"`
+	ConsensusBranchID uint32   `json:"consensusBranchId,omitempty"`
+	Inputs            []*TxIn  `json:"inputs"`
+	Outputs           []*TxOut `json:"outputs"`
+	LockTime          uint32   `json:"locktime"`
+	ExpiryHeight      uint32   `json:"expiryHeight,omitempty"`
+
+	ValueBalance    int64                `json:"valueBalance,omitempty"`
+	ShieldedSpends  []*SpendDescription  `json:"shieldedSpends,omitempty"`
+	ShieldedOutputs []*OutputDescription `json:"shieldedOutputs,omitempty"`
+
 	JoinSplits []*JSDescription `json:"joinSplits,omitempty"`
 	JSPubKey   []byte           `json:"jsPubKey,omitempty"`
 	JSSig      []byte           `json:"jsSig,omitempty"`
+
+	BindingSig []byte `json:"bindingSig,omitempty"`
+
+	Orchard *OrchardBundle `json:"orchard,omitempty"`
+
+	// Params selects the network used to derive addresses. MainNetParams is
+	// assumed when it is nil.
+	Params *Params `json:"-"`
+}
+
+func (t *Tx) params() *Params {
+	if t.Params == nil {
+		return MainNetParams
+	}
+	return t.Params
+}
+
+// header returns the serialized form of the version field, with the
+// fOverwintered flag in the high bit.
+func (t *Tx) header() uint32 {
+	h := t.Version
+	if t.Overwintered {
+		h |= 1 << 31
+	}
+	return h
+}
+
+// isV4 reports whether t has the Sapling (v4) layout. As in zcashd, the
+// layout follows from the fOverwintered flag together with the version: a
+// transaction without the flag has the Sprout layout whatever its version,
+// with JoinSplits from v2 on.
+func (t *Tx) isV4() bool {
+	return t.Overwintered && t.Version == 4
 }
 
-func (t *Tx) Cid() *cid.Cid {
-	h, _ := mh.Sum(t.RawData(), mh.DBL_SHA2_256, -1)
-	return cid.NewCidV1(cid.ZcashTx, h)
+// isV5 reports whether t has the ZIP 225 (v5) layout.
+func (t *Tx) isV5() bool {
+	return t.Overwintered && t.Version == 5
+}
+
+// Cid returns the transaction's CID. For legacy transactions this is the
+// double SHA-256 of the data, which is also the txid. A v5 txid is not a hash
+// of the serialized transaction, so v5 transactions are addressed by the
+// BLAKE2b-256 of their data instead; see TxidCid and TxLookup.
+//
+// Cid is the CID a transaction is stored under, and the one DecodeNode
+// checks. Links to a transaction from other nodes (TxIn.PrevTx, the leaves of
+// the TxTree) carry its TxidCid instead, as do MerkleProof.Tx and the
+// matches a PartialMerkleTree returns. Functions taking a transaction's CID
+// accept either.
+func (t *Tx) Cid() cid.Cid {
+	if t.isV5() {
+		h, _ := mh.Encode(blake2bPersonal(32, nil, t.RawData()), mh.BLAKE2B_MIN+31)
+		return cid.NewCidV1(cid.ZcashTx, h)
+	}
+	return t.TxidCid()
+}
+
+// TxidCid returns the CID built from the transaction's txid, which is how
+// prevouts and TxTree nodes link to it. It is the same as Cid for all but v5
+// transactions, whose data can't be fetched from a blockstore by it; see
+// TxLookup.
+func (t *Tx) TxidCid() cid.Cid {
+	return hashToCid(t.ZecSha(), cid.ZcashTx)
 }
 
 func (t *Tx) Links() []*node.Link {
 	var out []*node.Link
 	for i, input := range t.Inputs {
-		if input.PrevTx != nil {
+		if input.PrevTx.Defined() {
 			lnk := &node.Link{Cid: input.PrevTx}
 			lnk.Name = fmt.Sprintf("inputs/%d/prevTx", i)
 			out = append(out, lnk)
@@ -40,11 +116,33 @@ func (t *Tx) Links() []*node.Link {
 	return out
 }
 
+// RawData returns the serialized transaction. Decoded transactions always
+// serialize, so it panics on a transaction that EncodeTx rejects, which can
+// only have been put together by hand; check those with EncodeTx first.
 func (t *Tx) RawData() []byte {
+	b, err := EncodeTx(t)
+	if err != nil {
+		panic(fmt.Sprintf("cannot serialize transaction: %s", err))
+	}
+	return b
+}
+
+// EncodeTx serializes t. It fails if a JoinSplit doesn't carry the kind of
+// proof the transaction's layout calls for.
+func EncodeTx(t *Tx) ([]byte, error) {
+	if t.isV5() {
+		return t.rawDataV5(), nil
+	}
+
 	buf := new(bytes.Buffer)
 	i := make([]byte, 4)
-	binary.LittleEndian.PutUint32(i, t.Version)
+	binary.LittleEndian.PutUint32(i, t.header())
 	buf.Write(i)
+	if t.Overwintered {
+		binary.LittleEndian.PutUint32(i, t.VersionGroupID)
+		buf.Write(i)
+	}
+
 	writeVarInt(buf, uint64(len(t.Inputs)))
 	for _, inp := range t.Inputs {
 		inp.WriteTo(buf)
@@ -57,17 +155,107 @@ func (t *Tx) RawData() []byte {
 
 	binary.LittleEndian.PutUint32(i, t.LockTime)
 	buf.Write(i)
-	if t.Version == 1 {
-		return buf.Bytes()
+	if t.Overwintered {
+		binary.LittleEndian.PutUint32(i, t.ExpiryHeight)
+		buf.Write(i)
+	}
+
+	if t.Version < 2 {
+		return buf.Bytes(), nil
+	}
+
+	if t.isV4() {
+		v := make([]byte, 8)
+		binary.LittleEndian.PutUint64(v, uint64(t.ValueBalance))
+		buf.Write(v)
+
+		writeVarInt(buf, uint64(len(t.ShieldedSpends)))
+		for _, sd := range t.ShieldedSpends {
+			sd.WriteTo(buf)
+		}
+
+		writeVarInt(buf, uint64(len(t.ShieldedOutputs)))
+		for _, od := range t.ShieldedOutputs {
+			od.WriteTo(buf)
+		}
 	}
 
+	system := t.jsProofSystem()
 	writeVarInt(buf, uint64(len(t.JoinSplits)))
-	for _, js := range t.JoinSplits {
-		js.WriteTo(buf)
+	for i, js := range t.JoinSplits {
+		if err := js.WriteTo(buf, system); err != nil {
+			return nil, fmt.Errorf("joinsplit %d: %s", i, err)
+		}
+	}
+
+	if len(t.JoinSplits) > 0 {
+		buf.Write(t.JSPubKey)
+		buf.Write(t.JSSig)
+	}
+
+	if len(t.ShieldedSpends)+len(t.ShieldedOutputs) > 0 {
+		buf.Write(t.BindingSig)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// rawDataV5 serializes a transaction in the ZIP 225 layout, where the Sapling
+// proofs and signatures are split out from their descriptions.
+func (t *Tx) rawDataV5() []byte {
+	buf := new(bytes.Buffer)
+	i := make([]byte, 4)
+	for _, v := range []uint32{t.header(), t.VersionGroupID, t.ConsensusBranchID, t.LockTime, t.ExpiryHeight} {
+		binary.LittleEndian.PutUint32(i, v)
+		buf.Write(i)
+	}
+
+	writeVarInt(buf, uint64(len(t.Inputs)))
+	for _, inp := range t.Inputs {
+		inp.WriteTo(buf)
 	}
 
-	buf.Write(t.JSPubKey)
-	buf.Write(t.JSSig)
+	writeVarInt(buf, uint64(len(t.Outputs)))
+	for _, out := range t.Outputs {
+		out.WriteTo(buf)
+	}
+
+	writeVarInt(buf, uint64(len(t.ShieldedSpends)))
+	for _, sd := range t.ShieldedSpends {
+		writeMany(buf, sd.Cv, sd.Nullifier, sd.Rk)
+	}
+
+	writeVarInt(buf, uint64(len(t.ShieldedOutputs)))
+	for _, od := range t.ShieldedOutputs {
+		writeMany(buf, od.Cv, od.Cmu, od.EphemeralKey, od.EncCiphertext, od.OutCiphertext)
+	}
+
+	if len(t.ShieldedSpends)+len(t.ShieldedOutputs) > 0 {
+		v := make([]byte, 8)
+		binary.LittleEndian.PutUint64(v, uint64(t.ValueBalance))
+		buf.Write(v)
+	}
+
+	if len(t.ShieldedSpends) > 0 {
+		// all v5 spends share a single anchor
+		buf.Write(t.ShieldedSpends[0].Anchor)
+	}
+
+	for _, sd := range t.ShieldedSpends {
+		buf.Write(sd.Proof)
+	}
+	for _, sd := range t.ShieldedSpends {
+		buf.Write(sd.SpendAuthSig)
+	}
+	for _, od := range t.ShieldedOutputs {
+		buf.Write(od.Proof)
+	}
+
+	if len(t.ShieldedSpends)+len(t.ShieldedOutputs) > 0 {
+		buf.Write(t.BindingSig)
+	}
+
+	t.Orchard.WriteTo(buf)
 
 	return buf.Bytes()
 }
@@ -84,6 +272,12 @@ func (t *Tx) Resolve(path []string) (interface{}, []string, error) {
 		return t.Version, path[1:], nil
 	case "lockTime":
 		return t.LockTime, path[1:], nil
+	case "expiryHeight":
+		return t.ExpiryHeight, path[1:], nil
+	case "versionGroupId":
+		return t.VersionGroupID, path[1:], nil
+	case "consensusBranchId":
+		return t.ConsensusBranchID, path[1:], nil
 	case "inputs":
 		if len(path) == 1 {
 			return t.Inputs, nil, nil
@@ -105,14 +299,33 @@ func (t *Tx) Resolve(path []string) (interface{}, []string, error) {
 
 		switch path[2] {
 		case "prevTx":
-			if inp.PrevTx == nil {
+			if !inp.PrevTx.Defined() {
 				return nil, nil, fmt.Errorf("no such link")
 			}
 			return &node.Link{Cid: inp.PrevTx}, path[3:], nil
+		case "prevTxIndex":
+			return inp.PrevTxIndex, path[3:], nil
+		case "prevOut":
+			// link to the spent output by continuing into the previous
+			// transaction's outputs
+			if !inp.PrevTx.Defined() {
+				return nil, nil, fmt.Errorf("no such link")
+			}
+			rest := append([]string{"outputs", strconv.FormatUint(uint64(inp.PrevTxIndex), 10)}, path[3:]...)
+			return &node.Link{Cid: inp.PrevTx}, rest, nil
 		case "seqNo":
 			return inp.SeqNo, path[3:], nil
 		case "script":
-			return inp.Script, path[3:], nil
+			if len(path) == 3 {
+				return inp.Script, nil, nil
+			}
+
+			info := script.Analyze(inp.Script)
+			if !inp.PrevTx.Defined() {
+				info.Type = script.Coinbase
+				info.Data = nil
+			}
+			return info.Resolve(path[3:])
 		default:
 			return nil, nil, fmt.Errorf("no such link")
 		}
@@ -138,11 +351,51 @@ func (t *Tx) Resolve(path []string) (interface{}, []string, error) {
 		switch path[2] {
 		case "value":
 			return outp.Value, path[3:], nil
+		case "address":
+			addr, err := script.Address(outp.Script, t.params().Addresses)
+			if err != nil {
+				return nil, nil, err
+			}
+			return addr, path[3:], nil
 		case "script":
-			return outp.Script, path[3:], nil
+			if len(path) == 3 {
+				return outp.Script, nil, nil
+			}
+			return script.Analyze(outp.Script).Resolve(path[3:])
 		default:
 			return nil, nil, fmt.Errorf("no such link")
 		}
+	case "valueBalance":
+		return t.ValueBalance, path[1:], nil
+	case "shieldedSpends":
+		if len(path) == 1 {
+			return t.ShieldedSpends, nil, nil
+		}
+
+		index, err := parseIndex(path[1], len(t.ShieldedSpends))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return t.ShieldedSpends[index].Resolve(path[2:])
+	case "shieldedOutputs":
+		if len(path) == 1 {
+			return t.ShieldedOutputs, nil, nil
+		}
+
+		index, err := parseIndex(path[1], len(t.ShieldedOutputs))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return t.ShieldedOutputs[index].Resolve(path[2:])
+	case "bindingSig":
+		return t.BindingSig, path[1:], nil
+	case "orchard":
+		if t.Orchard == nil {
+			return nil, nil, fmt.Errorf("no such link")
+		}
+		return t.Orchard.Resolve(path[1:])
 	case "joinSplits":
 		return t.JoinSplits, path[1:], nil
 	case "jsPubKey":
@@ -154,6 +407,20 @@ func (t *Tx) Resolve(path []string) (interface{}, []string, error) {
 	}
 }
 
+// parseIndex parses a path component as an index into a list of length n.
+func parseIndex(s string, n int) (int, error) {
+	index, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+
+	if index >= n || index < 0 {
+		return 0, fmt.Errorf("index out of range")
+	}
+
+	return index, nil
+}
+
 func (t *Tx) ResolveLink(path []string) (*node.Link, []string, error) {
 	i, rest, err := t.Resolve(path)
 	if err != nil {
@@ -196,7 +463,10 @@ func (t *Tx) Tree(p string, depth int) []string {
 	case "outputs":
 		return t.treeOutputs(nil, depth+1)
 	case "":
-		out := []string{"version", "timeLock", "inputs", "outputs", "joinSplits", "jsPubKey", "jsSig"}
+		out := []string{"version", "versionGroupId", "timeLock", "expiryHeight", "inputs", "outputs", "valueBalance", "shieldedSpends", "shieldedOutputs", "joinSplits", "jsPubKey", "jsSig", "bindingSig"}
+		if t.isV5() {
+			out = append(out, "consensusBranchId", "orchard")
+		}
 		out = t.treeInputs(out, depth)
 		out = t.treeOutputs(out, depth)
 		return out
@@ -214,7 +484,10 @@ func (t *Tx) treeInputs(out []string, depth int) []string {
 		inp := "inputs/" + fmt.Sprint(i)
 		out = append(out, inp)
 		if depth > 2 {
-			out = append(out, inp+"/prevTx", inp+"/seqNo", inp+"/script")
+			out = append(out, inp+"/prevTx", inp+"/prevTxIndex", inp+"/prevOut", inp+"/seqNo", inp+"/script")
+		}
+		if depth > 3 {
+			out = append(out, inp+"/script/type", inp+"/script/asm", inp+"/script/data")
 		}
 	}
 	return out
@@ -229,17 +502,40 @@ func (t *Tx) treeOutputs(out []string, depth int) []string {
 		o := "outputs/" + fmt.Sprint(i)
 		out = append(out, o)
 		if depth > 2 {
-			out = append(out, o+"/script", o+"/value")
+			out = append(out, o+"/script", o+"/value", o+"/address")
+		}
+		if depth > 3 {
+			out = append(out, o+"/script/type", o+"/script/asm", o+"/script/data")
 		}
 	}
 	return out
 }
 
+// ZecSha returns the txid of this transaction in internal byte order.
 func (t *Tx) ZecSha() []byte {
+	if t.isV5() {
+		return t.txidDigest()
+	}
+
 	mh, _ := mh.Sum(t.RawData(), mh.DBL_SHA2_256, -1)
 	return []byte(mh[2:])
 }
 
+// AuthDigest returns the ZIP 244 authorizing data commitment. Transactions
+// prior to v5 have no such commitment and use the all 0xFF placeholder.
+func (t *Tx) AuthDigest() []byte {
+	if t.isV5() {
+		return t.authDigest()
+	}
+
+	return bytes.Repeat([]byte{0xff}, 32)
+}
+
+// Wtxid returns the 64 byte wtxid, the txid followed by the auth digest.
+func (t *Tx) Wtxid() []byte {
+	return append(t.ZecSha(), t.AuthDigest()...)
+}
+
 func (t *Tx) HexHash() string {
 	return hex.EncodeToString(revString(t.ZecSha()))
 }
@@ -251,15 +547,17 @@ func txHashToLink(b []byte) *node.Link {
 }
 
 type TxIn struct {
-	PrevTx      *cid.Cid `json:"txid,omitempty"`
-	PrevTxIndex uint32   `json:"vout"`
-	Script      []byte   `json:"script"`
-	SeqNo       uint32   `json:"sequence"`
+	// PrevTx is the TxidCid of the transaction spent from, which for a v5
+	// transaction is not its Cid. It is cid.Undef for a coinbase input.
+	PrevTx      cid.Cid `json:"txid,omitempty"`
+	PrevTxIndex uint32  `json:"vout"`
+	Script      []byte  `json:"script"`
+	SeqNo       uint32  `json:"sequence"`
 }
 
 func (i *TxIn) WriteTo(w io.Writer) error {
 	buf := make([]byte, 36)
-	if i.PrevTx != nil {
+	if i.PrevTx.Defined() {
 		copy(buf[:32], cidToHash(i.PrevTx))
 	}
 	binary.LittleEndian.PutUint32(buf[32:36], i.PrevTxIndex)
@@ -9,6 +9,8 @@ import (
 	mh "github.com/multiformats/go-multihash"
 )
 
+// TxTree is a node of a block's merkle tree. In the bottom layer Left and
+// Right link to transactions by their TxidCid.
 type TxTree struct {
 	Left  *node.Link
 	Right *node.Link
@@ -18,7 +20,7 @@ func (t *TxTree) ZECSha() []byte {
 	return cidToHash(t.Cid())
 }
 
-func (t *TxTree) Cid() *cid.Cid {
+func (t *TxTree) Cid() cid.Cid {
 	h, _ := mh.Sum(t.RawData(), mh.DBL_SHA2_256, -1)
 	return cid.NewCidV1(cid.ZcashTx, h)
 }
@@ -60,7 +62,7 @@ func (t *TxTree) Resolve(path []string) (interface{}, []string, error) {
 }
 
 func (t *TxTree) MarshalJSON() ([]byte, error) {
-	return json.Marshal([]*cid.Cid{t.Left.Cid, t.Right.Cid})
+	return json.Marshal([]cid.Cid{t.Left.Cid, t.Right.Cid})
 }
 
 func (t *TxTree) Copy() node.Node {
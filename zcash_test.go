@@ -2,12 +2,20 @@ package ipldzec
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"io"
 	"io/ioutil"
+	"math/big"
+	"strconv"
+	"strings"
 	"testing"
 
+	cid "github.com/ipfs/go-cid"
 	node "github.com/ipfs/go-ipld-format"
+	mh "github.com/multiformats/go-multihash"
 )
 
 func loadTestBlock() (*Block, []node.Node, []byte, error) {
@@ -86,3 +94,1955 @@ func TestJsonMarshaling(t *testing.T) {
 		t.Fatal("got wrong link")
 	}
 }
+
+func TestOverwinterTxRoundTrip(t *testing.T) {
+	tx := &Tx{
+		Overwintered:   true,
+		Version:        3,
+		VersionGroupID: OverwinterVersionGroupID,
+		Inputs: []*TxIn{
+			{
+				PrevTx:      hashToCid(bytes.Repeat([]byte{1}, 32), cid.ZcashTx),
+				PrevTxIndex: 1,
+				Script:      []byte{0x51},
+				SeqNo:       0xffffffff,
+			},
+		},
+		Outputs: []*TxOut{
+			{Value: 5000, Script: []byte{0x51}},
+		},
+		LockTime:     0,
+		ExpiryHeight: 347520,
+	}
+
+	data := tx.RawData()
+	if !bytes.Equal(data[:4], []byte{0x03, 0x00, 0x00, 0x80}) {
+		t.Fatal("header should have the overwintered bit set")
+	}
+
+	ntx, err := DecodeTx(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ntx.Overwintered || ntx.Version != 3 || ntx.VersionGroupID != OverwinterVersionGroupID {
+		t.Fatal("version fields not decoded correctly")
+	}
+
+	if !bytes.Equal(ntx.RawData(), data) {
+		t.Fatal("transaction did not round trip")
+	}
+
+	exp, _, err := ntx.Resolve([]string{"expiryHeight"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp.(uint32) != 347520 {
+		t.Fatal("got wrong expiry height")
+	}
+}
+
+func fill(n int, b byte) []byte {
+	return bytes.Repeat([]byte{b}, n)
+}
+
+func TestSaplingTxRoundTrip(t *testing.T) {
+	tx := &Tx{
+		Overwintered:   true,
+		Version:        4,
+		VersionGroupID: SaplingVersionGroupID,
+		ExpiryHeight:   419300,
+		ValueBalance:   -20000,
+		ShieldedSpends: []*SpendDescription{
+			{
+				Cv:           fill(32, 1),
+				Anchor:       fill(32, 2),
+				Nullifier:    fill(32, 3),
+				Rk:           fill(32, 4),
+				Proof:        fill(192, 5),
+				SpendAuthSig: fill(64, 6),
+			},
+		},
+		ShieldedOutputs: []*OutputDescription{
+			{
+				Cv:            fill(32, 1),
+				Cmu:           fill(32, 2),
+				EphemeralKey:  fill(32, 3),
+				EncCiphertext: fill(580, 4),
+				OutCiphertext: fill(80, 5),
+				Proof:         fill(192, 6),
+			},
+		},
+		JoinSplits: []*JSDescription{testJoinSplit(192)},
+		JSPubKey:   fill(32, 13),
+		JSSig:      fill(64, 14),
+		BindingSig: fill(64, 15),
+	}
+
+	data := tx.RawData()
+	ntx, err := DecodeTx(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(ntx.RawData(), data) {
+		t.Fatal("transaction did not round trip")
+	}
+
+	if ntx.ValueBalance != -20000 {
+		t.Fatal("got wrong value balance")
+	}
+
+	nf, _, err := ntx.Resolve([]string{"shieldedSpends", "0", "nullifier"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(nf.([]byte), fill(32, 3)) {
+		t.Fatal("got wrong nullifier")
+	}
+
+	cmu, _, err := ntx.Resolve([]string{"shieldedOutputs", "0", "cmu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cmu.([]byte), fill(32, 2)) {
+		t.Fatal("got wrong note commitment")
+	}
+}
+
+func TestLegacyLayoutIgnoresVersion(t *testing.T) {
+	// without the fOverwintered flag a v4 transaction is laid out like a v2
+	// one, with no Sapling fields
+	v2 := tx64(2, cid.Undef)
+	v4 := tx64(2, cid.Undef)
+	v4.Version = 4
+
+	data := v4.RawData()
+	if !bytes.Equal(data[4:], v2.RawData()[4:]) {
+		t.Fatal("legacy v4 transaction should be serialized like a v2 one")
+	}
+
+	ntx, err := DecodeTxWithOptions(data, DecodeOptions{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ntx.Overwintered || ntx.Version != 4 || len(ntx.Outputs) != 1 {
+		t.Fatal("legacy v4 transaction did not decode with the v2 layout")
+	}
+
+	// nor is a v5 one identified by a ZIP 244 txid
+	v5 := tx64(2, cid.Undef)
+	v5.Version = 5
+	if !bytes.Equal(v5.RawData()[4:], v2.RawData()[4:]) {
+		t.Fatal("legacy v5 transaction should be serialized like a v2 one")
+	}
+
+	h, _ := mh.Sum(v5.RawData(), mh.DBL_SHA2_256, -1)
+	if !bytes.Equal(v5.ZecSha(), h[2:]) {
+		t.Fatal("legacy v5 txid should be the double sha256 of its data")
+	}
+}
+
+func testV5Tx() *Tx {
+	anchor := fill(32, 9)
+	return &Tx{
+		Overwintered:      true,
+		Version:           5,
+		VersionGroupID:    NU5VersionGroupID,
+		ConsensusBranchID: 0xc2d6d0b4,
+		ExpiryHeight:      1687144,
+		Inputs: []*TxIn{
+			{
+				PrevTx:      hashToCid(fill(32, 1), cid.ZcashTx),
+				PrevTxIndex: 0,
+				Script:      []byte{0x51},
+				SeqNo:       0xffffffff,
+			},
+		},
+		Outputs: []*TxOut{
+			{Value: 1000, Script: []byte{0x51}},
+		},
+		ValueBalance: 500,
+		ShieldedSpends: []*SpendDescription{
+			{Cv: fill(32, 1), Anchor: anchor, Nullifier: fill(32, 2), Rk: fill(32, 3), Proof: fill(192, 4), SpendAuthSig: fill(64, 5)},
+			{Cv: fill(32, 6), Anchor: anchor, Nullifier: fill(32, 7), Rk: fill(32, 8), Proof: fill(192, 9), SpendAuthSig: fill(64, 10)},
+		},
+		ShieldedOutputs: []*OutputDescription{
+			{Cv: fill(32, 1), Cmu: fill(32, 2), EphemeralKey: fill(32, 3), EncCiphertext: fill(580, 4), OutCiphertext: fill(80, 5), Proof: fill(192, 6)},
+		},
+		BindingSig: fill(64, 11),
+		Orchard: &OrchardBundle{
+			Actions: []*OrchardAction{
+				{
+					Cv:            fill(32, 1),
+					Nullifier:     fill(32, 2),
+					Rk:            fill(32, 3),
+					Cmx:           fill(32, 4),
+					EphemeralKey:  fill(32, 5),
+					EncCiphertext: fill(580, 6),
+					OutCiphertext: fill(80, 7),
+					SpendAuthSig:  fill(64, 8),
+				},
+			},
+			Flags:        OrchardSpendsEnabled | OrchardOutputsEnabled,
+			ValueBalance: -1500,
+			Anchor:       fill(32, 12),
+			Proof:        fill(2720, 13),
+			BindingSig:   fill(64, 14),
+		},
+	}
+}
+
+func TestV5TxRoundTrip(t *testing.T) {
+	tx := testV5Tx()
+
+	data := tx.RawData()
+	ntx, err := DecodeTx(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(ntx.RawData(), data) {
+		t.Fatal("transaction did not round trip")
+	}
+
+	if ntx.ConsensusBranchID != tx.ConsensusBranchID || ntx.ExpiryHeight != tx.ExpiryHeight {
+		t.Fatal("header fields not decoded correctly")
+	}
+
+	anchor, _, err := ntx.Resolve([]string{"shieldedSpends", "1", "anchor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(anchor.([]byte), fill(32, 9)) {
+		t.Fatal("spends should share the bundle anchor")
+	}
+
+	sig, _, err := ntx.Resolve([]string{"orchard", "actions", "0", "spendAuthSig"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sig.([]byte), fill(64, 8)) {
+		t.Fatal("got wrong orchard signature")
+	}
+
+	vb, _, err := ntx.Resolve([]string{"orchard", "valueBalance"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vb.(int64) != -1500 {
+		t.Fatal("got wrong orchard value balance")
+	}
+}
+
+func TestV5TxidIgnoresAuthData(t *testing.T) {
+	tx := testV5Tx()
+	txid := tx.ZecSha()
+	auth := tx.AuthDigest()
+
+	c := tx.Cid()
+	sum, err := c.Prefix().Sum(tx.RawData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sum.Equals(c) {
+		t.Fatal("cid should be the hash of the transaction data")
+	}
+
+	if c.Equals(tx.TxidCid()) || !bytes.Equal(cidToHash(tx.TxidCid()), txid) {
+		t.Fatal("txid cid should carry the zip 244 txid")
+	}
+
+	tx.Orchard.Actions[0].SpendAuthSig = fill(64, 0xaa)
+	tx.ShieldedSpends[0].Proof = fill(192, 0xbb)
+
+	if !bytes.Equal(tx.ZecSha(), txid) {
+		t.Fatal("txid should not commit to signatures or proofs")
+	}
+
+	if bytes.Equal(tx.AuthDigest(), auth) {
+		t.Fatal("auth digest should commit to signatures and proofs")
+	}
+
+	tx.ExpiryHeight++
+	if bytes.Equal(tx.ZecSha(), txid) {
+		t.Fatal("txid should commit to the expiry height")
+	}
+
+	if len(tx.Wtxid()) != 64 {
+		t.Fatal("wtxid should be 64 bytes")
+	}
+}
+
+func TestBlake2b(t *testing.T) {
+	// BLAKE2b-512("abc") from RFC 7693, appendix A
+	exp := "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"
+	if out := hex.EncodeToString(blake2bPersonal(64, nil, []byte("a"), []byte("bc"))); out != exp {
+		t.Fatalf("got %s, expected %s", out, exp)
+	}
+
+	if bytes.Equal(blake2b256([]byte("ZTxIdHeadersHash")), blake2b256([]byte("ZTxIdOrchardHash"))) {
+		t.Fatal("personalization should change the digest")
+	}
+
+	// digests from Python's hashlib.blake2b, which takes the personalization
+	// as its person argument. The inputs are the bytes 0, 1, 2, ... of the
+	// given length, and cover the empty message and messages ending on and
+	// just past a block boundary.
+	cases := []struct {
+		size   int
+		person string
+		length int
+		exp    string
+	}{
+		{64, "", 0, "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce"},
+		{32, "", 3, "3d8c3d594928271f44aad7a04b177154806867bcf918e1549c0bc16f9da2b09b"},
+		{32, "ZcashTxHash_\xb4\xd0\xd6\xc2", 0, "193cbc461dfaf3780d28e26e16bba47a011a2c625eddf18ad7edddbc3e371336"},
+		{32, "ZTxIdHeadersHash", 128, "017eeab5695d72917c5352a5d179242630293f7d740697eda0694592c8c035cc"},
+		{32, "ZTxIdOrchardHash", 129, "f7ff5e0b03840e369b9f842879de395532a535ccfe33b883410d929913466099"},
+		{64, "ZTxIdSaplingHash", 256, "50b2f9baf1ae92a18ce2b34fa17c18b2fc883a77ae0d08398eac52e6f467df16dd7042b8537e72c78852082ea5ddb6c8698422af78f6a59fe086cb0dd55e634d"},
+		{20, "ZTxIdOutputsHash", 1000, "b35b2f82276d0ea47ee20544f572bfbe73c058ae"},
+	}
+
+	for i, c := range cases {
+		data := make([]byte, c.length)
+		for j := range data {
+			data[j] = byte(j)
+		}
+
+		if out := hex.EncodeToString(blake2bPersonal(c.size, []byte(c.person), data)); out != c.exp {
+			t.Errorf("case %d: got %s, expected %s", i, out, c.exp)
+		}
+	}
+}
+
+func testJoinSplit(proofSize int) *JSDescription {
+	return &JSDescription{
+		OldVal:       10,
+		NewVal:       0,
+		Anchor:       fill(32, 1),
+		Nullifiers:   [][]byte{fill(32, 2), fill(32, 3)},
+		Commitments:  [][]byte{fill(32, 4), fill(32, 5)},
+		EphemeralKey: fill(32, 6),
+		RandomSeed:   fill(32, 7),
+		Macs:         [][]byte{fill(32, 8), fill(32, 9)},
+		Proof:        fill(proofSize, 10),
+		CipherTexts:  [][]byte{fill(601, 11), fill(601, 12)},
+	}
+}
+
+func TestJoinSplitProofSystem(t *testing.T) {
+	_, txs, _, err := loadTestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var found bool
+	for _, n := range txs {
+		tx, ok := n.(*Tx)
+		if !ok {
+			continue
+		}
+		for _, js := range tx.JoinSplits {
+			found = true
+			if js.ProofSystem != PHGR13 || len(js.Proof) != 296 {
+				t.Fatal("pre-sapling joinsplits should use PHGR13 proofs")
+			}
+		}
+	}
+	if !found {
+		t.Fatal("test block should contain joinsplits")
+	}
+
+	tx := &Tx{
+		Overwintered:   true,
+		Version:        4,
+		VersionGroupID: SaplingVersionGroupID,
+		JoinSplits:     []*JSDescription{testJoinSplit(192)},
+		JSPubKey:       fill(32, 1),
+		JSSig:          fill(64, 2),
+	}
+
+	ntx, err := DecodeTx(tx.RawData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ntx.JoinSplits[0].ProofSystem != Groth16 {
+		t.Fatal("sapling joinsplits should use Groth16 proofs")
+	}
+
+	tx.JoinSplits = []*JSDescription{testJoinSplit(296)}
+	if _, err := EncodeTx(tx); err == nil {
+		t.Fatal("writing a PHGR13 proof into a v4 transaction should fail")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("a transaction that can't be serialized should have no cid")
+			}
+		}()
+		tx.Cid()
+	}()
+
+	js := testJoinSplit(192)
+	js.ProofSystem = PHGR13
+	if err := js.WriteTo(new(bytes.Buffer), Groth16); err == nil {
+		t.Fatal("a joinsplit should not be written with another proof system")
+	}
+
+	// the proof system follows the layout, which legacy transactions don't
+	// take from their version
+	legacy := &Tx{
+		Version:    4,
+		JoinSplits: []*JSDescription{testJoinSplit(296)},
+		JSPubKey:   fill(32, 1),
+		JSSig:      fill(64, 2),
+	}
+	ntx, err = DecodeTx(legacy.RawData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ntx.JoinSplits[0].ProofSystem != PHGR13 {
+		t.Fatal("legacy joinsplits should use PHGR13 proofs")
+	}
+}
+
+func TestReservedHashInterpretation(t *testing.T) {
+	blk, nds, _, err := loadTestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if blk.Height != 24202 {
+		t.Fatalf("expected height from coinbase to be 24202, got %d", blk.Height)
+	}
+
+	if name, _, _ := blk.Commitment(nil); name != ReservedField {
+		t.Fatal("pre-sapling blocks have no commitment in the reserved hash")
+	}
+	if name, _, _ := blk.Commitment(TestNetParams); name != ReservedField {
+		t.Fatal("pre-sapling blocks have no commitment in the reserved hash")
+	}
+
+	if _, _, err := blk.Resolve([]string{FinalSaplingRootField}); err == nil {
+		t.Fatal("shouldnt resolve a sapling root before sapling activation")
+	}
+
+	blk.Height = MainNetParams.HeartwoodHeight
+	val, _, err := blk.Resolve([]string{LightClientRootField})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(val.([]byte), blk.ReservedHash) {
+		t.Fatal("got wrong light client root")
+	}
+
+	blk.Height = MainNetParams.NU5Height
+	if _, _, err := blk.Resolve([]string{BlockCommitmentsField}); err != nil {
+		t.Fatal(err)
+	}
+
+	header, err := DecodeBlock(blk.RawData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := header.Commitment(nil); err != ErrUnknownHeight {
+		t.Fatalf("expected ErrUnknownHeight for a header-only block, got %v", err)
+	}
+	if _, _, err := header.Resolve([]string{FinalSaplingRootField}); err != ErrUnknownHeight {
+		t.Fatalf("expected ErrUnknownHeight resolving a header-only block, got %v", err)
+	}
+
+	genesis := *header
+	genesis.Parent = hashToCid(make([]byte, 32), cid.ZcashBlock)
+	if name, _, err := genesis.Commitment(nil); err != nil || name != ReservedField {
+		t.Fatal("the genesis block is known to be at height zero")
+	}
+
+	header.Height = MainNetParams.SaplingHeight
+	if name, _, _ := header.Commitment(nil); name != FinalSaplingRootField {
+		t.Fatal("a header-only block should use the height it is given")
+	}
+
+	ng := testNodeGetter{}
+	ng.add(header)
+	ng.add(nds...)
+	full, _, err := FetchBlock(context.Background(), ng, header.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full.Height != 24202 || full.TxCount != 6 || header.Height != MainNetParams.SaplingHeight {
+		t.Fatal("fetching a block should read its height from the coinbase")
+	}
+
+	// a block from a blockstore is decoded from its header alone
+	nd, err := DecodeNode(&rawBlock{data: blk.RawData(), cid: blk.Cid()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range nd.Tree("", -1) {
+		if p == "height" {
+			t.Fatal("a header-only block should not list its unknown height")
+		}
+	}
+	for _, p := range []string{"height", FinalSaplingRootField} {
+		if _, _, err := nd.Resolve([]string{p}); err != ErrUnknownHeight {
+			t.Fatalf("expected ErrUnknownHeight resolving %s, got %v", p, err)
+		}
+	}
+
+	ng = testNodeGetter{}
+	ng.add(nd)
+	ng.add(nds...)
+	r := NewResolver(ng)
+	height, _, err := r.Resolve(context.Background(), nd.Cid(), "height")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != uint32(24202) {
+		t.Fatalf("the resolver should read the height from the coinbase, got %v", height)
+	}
+	if _, _, err := r.Resolve(context.Background(), nd.Cid(), FinalSaplingRootField); err == nil || err == ErrUnknownHeight {
+		t.Fatalf("a pre-sapling block found to be at its height has no sapling root, got %v", err)
+	}
+	if nd.(*Block).Height != 0 {
+		t.Fatal("the resolver should not modify the getter's block")
+	}
+}
+
+func TestScriptResolution(t *testing.T) {
+	_, txs, _, err := loadTestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coinbase := txs[0].(*Tx)
+	typ, _, err := coinbase.Resolve([]string{"inputs", "0", "script", "type"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != "coinbase" {
+		t.Fatal("coinbase input should be classified as such")
+	}
+
+	typ, _, err = coinbase.Resolve([]string{"outputs", "0", "script", "type"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ == "nonstandard" {
+		t.Fatal("coinbase output should have a standard script")
+	}
+
+	asm, _, err := coinbase.Resolve([]string{"outputs", "0", "script", "asm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asm.(string) == "" {
+		t.Fatal("should have disassembled the output script")
+	}
+}
+
+func TestOutputAddress(t *testing.T) {
+	fidata, err := ioutil.ReadFile("blk.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := hex.DecodeString(string(fidata[:len(fidata)-1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nds, err := DecodeBlockMessageWithOptions(data, DecodeOptions{Params: MainNetParams})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		tx, out int
+		addr    string
+	}{
+		{0, 0, "t1MGLc3pb6j6hGXe8YBZaoZBEShJysaWk3b"},
+		// the founders' reward address for this height
+		{0, 1, "t3cL9AucCajm3HXDhb5jBnJK2vapVoXsop3"},
+		{1, 0, "t1b3LzS2E5hhUx9tvs8BmEfvaVpz8s7GSiR"},
+		{2, 1, "t1NzNRTPe7KHQmyVWDHe4foxVveBngGJaQv"},
+	}
+
+	for _, c := range cases {
+		// nds starts with the block, so transaction i is nds[i+1]
+		addr, _, err := nds[c.tx+1].Resolve([]string{"outputs", strconv.Itoa(c.out), "address"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if addr != c.addr {
+			t.Fatalf("tx %d output %d: expected %s, got %v", c.tx, c.out, c.addr, addr)
+		}
+	}
+}
+
+func TestVerifySolution(t *testing.T) {
+	blk, _, _, err := loadTestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := blk.VerifySolution(EquihashN, EquihashK); err != nil {
+		t.Fatal(err)
+	}
+
+	blk.Nonce = append([]byte{}, blk.Nonce...)
+	blk.Nonce[0] ^= 1
+	if err := blk.VerifySolution(EquihashN, EquihashK); err == nil {
+		t.Fatal("solution should not verify against a different header")
+	}
+
+	if err := blk.VerifySolution(48, 5); err == nil {
+		t.Fatal("solution should not verify with the wrong parameters")
+	}
+}
+
+func TestCompactTarget(t *testing.T) {
+	target := CompactToBig(0x1d00ffff)
+	if target.Text(16) != "ffff0000000000000000000000000000000000000000000000000000" {
+		t.Fatalf("got wrong target %s", target.Text(16))
+	}
+
+	for _, bits := range []uint32{0x1d00ffff, 0x1f07ffff, 0x1c0168fd, 0x2007ffff} {
+		if BigToCompact(CompactToBig(bits)) != bits {
+			t.Fatalf("compact encoding of %x did not round trip", bits)
+		}
+	}
+}
+
+type testNodeGetter map[string]node.Node
+
+func (ng testNodeGetter) Get(ctx context.Context, c cid.Cid) (node.Node, error) {
+	nd, ok := ng[c.KeyString()]
+	if !ok {
+		return nil, node.ErrNotFound
+	}
+	return nd, nil
+}
+
+func (ng testNodeGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *node.NodeOption {
+	out := make(chan *node.NodeOption, len(cids))
+	for _, c := range cids {
+		nd, err := ng.Get(ctx, c)
+		out <- &node.NodeOption{Node: nd, Err: err}
+	}
+	close(out)
+	return out
+}
+
+func (ng testNodeGetter) LookupTx(ctx context.Context, txid []byte) (cid.Cid, error) {
+	for _, nd := range ng {
+		if tx, ok := nd.(*Tx); ok && bytes.Equal(tx.ZecSha(), txid) {
+			return tx.Cid(), nil
+		}
+	}
+	return cid.Undef, node.ErrNotFound
+}
+
+// countingGetter counts the nodes fetched one at a time.
+type countingGetter struct {
+	testNodeGetter
+	gets int
+}
+
+func (ng *countingGetter) Get(ctx context.Context, c cid.Cid) (node.Node, error) {
+	ng.gets++
+	return ng.testNodeGetter.Get(ctx, c)
+}
+
+func (ng testNodeGetter) add(nds ...node.Node) {
+	for _, nd := range nds {
+		ng[nd.Cid().KeyString()] = nd
+	}
+}
+
+func TestProofOfWork(t *testing.T) {
+	blk, _, _, err := loadTestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := blk.CheckProofOfWork(MainNetParams); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := blk.CheckProofOfWork(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	work := blk.Work()
+	if work.Sign() <= 0 {
+		t.Fatal("block should have positive work")
+	}
+
+	ng := testNodeGetter{}
+	ng.add(blk)
+
+	total, err := ChainWork(context.Background(), ng, blk.Cid(), blk.Parent, big.NewInt(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if total.Cmp(new(big.Int).Add(work, big.NewInt(1000))) != 0 {
+		t.Fatal("chain work should add the block's work to its parent's")
+	}
+
+	total, err = ChainWork(context.Background(), ng, blk.Cid(), blk.Parent, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total.Cmp(work) != 0 {
+		t.Fatal("without the stop block's chain work only the work above it should count")
+	}
+
+	if _, err := ChainWork(context.Background(), ng, blk.Cid(), cid.Undef, nil); err == nil {
+		t.Fatal("walking to genesis should fail without the ancestors")
+	}
+
+	other := *blk
+	other.Nonce = fill(32, 1)
+	ng[blk.Cid().KeyString()] = &other
+	if _, err := ChainWork(context.Background(), ng, blk.Cid(), blk.Parent, nil); err == nil {
+		t.Fatal("a getter returning another block should be caught")
+	}
+
+	easy := *blk
+	easy.Difficulty = BigToCompact(TestNetParams.PowLimit)
+	if err := easy.CheckProofOfWork(MainNetParams); err == nil {
+		t.Fatal("target above the mainnet limit should be rejected")
+	}
+
+	// without params the block's own network's limit applies
+	mainErr := easy.CheckProofOfWork(MainNetParams)
+	if err := easy.CheckProofOfWork(nil); err == nil || err.Error() != mainErr.Error() {
+		t.Fatal("a block without Params should be checked against mainnet")
+	}
+
+	easy.Params = TestNetParams
+	testErr := easy.CheckProofOfWork(TestNetParams)
+	if err := easy.CheckProofOfWork(nil); err == nil || err.Error() != testErr.Error() || err.Error() == mainErr.Error() {
+		t.Fatal("a testnet block should be checked against testnet")
+	}
+
+	hard := *blk
+	hard.Difficulty = 0x1c00ffff
+	if err := hard.CheckProofOfWork(MainNetParams); err == nil {
+		t.Fatal("hash above the target should be rejected")
+	}
+}
+
+func testChain(n int, spacing uint32, bits uint32) []*Block {
+	// newest first, like the ancestor lists used for difficulty checks
+	out := make([]*Block, n)
+	for i := range out {
+		out[i] = &Block{
+			Timestamp:  uint32(1500000000 - i*int(spacing)),
+			Difficulty: bits,
+		}
+	}
+	return out
+}
+
+func TestExpectedDifficulty(t *testing.T) {
+	const bits = 0x1c0168fd
+	height := MainNetParams.SaplingHeight
+
+	steady := testChain(DifficultyWindow, PreBlossomPowTargetSpacing, bits)
+	// dividing by the window timespan before scaling loses the low bits of
+	// the target, which the compact encoding then truncates
+	expected := uint32(bits - 1)
+	if d := ExpectedDifficulty(MainNetParams, height, steady[0].Timestamp+150, steady); d != expected {
+		t.Fatalf("difficulty should not change at the target spacing, got %08x", d)
+	}
+
+	// after blossom the same spacing is twice as slow as targeted
+	if d := ExpectedDifficulty(MainNetParams, MainNetParams.BlossomHeight, steady[0].Timestamp+150, steady); CompactToBig(d).Cmp(CompactToBig(bits)) <= 0 {
+		t.Fatal("difficulty should drop when blocks are slower than targeted")
+	}
+
+	fast := testChain(DifficultyWindow, 10, bits)
+	d := ExpectedDifficulty(MainNetParams, height, fast[0].Timestamp+10, fast)
+	window := int64(PowAveragingWindow * PreBlossomPowTargetSpacing)
+	limit := new(big.Int).Div(CompactToBig(bits), big.NewInt(window))
+	limit.Mul(limit, big.NewInt(window*(100-PowMaxAdjustUp)/100))
+	if d != BigToCompact(limit) {
+		t.Fatal("difficulty increase should be bounded")
+	}
+
+	if d := ExpectedDifficulty(MainNetParams, 10, steady[0].Timestamp+150, steady[:10]); d != BigToCompact(MainNetParams.PowLimit) {
+		t.Fatal("early blocks should use the proof of work limit")
+	}
+
+	late := steady[0].Timestamp + 7*PreBlossomPowTargetSpacing
+	if d := ExpectedDifficulty(TestNetParams, 300000, late, steady); d != BigToCompact(TestNetParams.PowLimit) {
+		t.Fatal("testnet should allow minimum difficulty blocks after a long gap")
+	}
+	if d := ExpectedDifficulty(MainNetParams, 300000, late, steady); d == BigToCompact(MainNetParams.PowLimit) {
+		t.Fatal("mainnet should not allow minimum difficulty blocks")
+	}
+
+	blk := &Block{Timestamp: steady[0].Timestamp + 150, Difficulty: expected}
+	if err := blk.CheckDifficulty(MainNetParams, height, steady); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifiedBlockDecoding(t *testing.T) {
+	_, _, data, err := loadTestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DecodeOptions{VerifyMerkleRoot: true}
+	nds, err := DecodeBlockMessageWithOptions(data, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var txs []*Tx
+	for _, nd := range nds[1:] {
+		if tx, ok := nd.(*Tx); ok {
+			txs = append(txs, tx)
+		}
+	}
+
+	blk := nds[0].(*Block)
+	if err := blk.CheckMerkleRoot(txs[:len(txs)-1]); err == nil {
+		t.Fatal("dropping a transaction should change the merkle root")
+	} else if _, ok := err.(*MerkleRootError); !ok {
+		t.Fatalf("expected a merkle root error, got %s", err)
+	}
+
+	// 6 transactions pad their third layer by duplicating the last node, so
+	// repeating the last two transactions yields the same root
+	mutated := append(txs[:len(txs):len(txs)], txs[4], txs[5])
+	if err := blk.CheckMerkleRoot(mutated); err != ErrMutatedMerkleTree {
+		t.Fatalf("expected mutated merkle tree error, got %v", err)
+	}
+}
+
+func TestMerkleProofs(t *testing.T) {
+	blk, nds, _, err := loadTestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ng := testNodeGetter{}
+	ng.add(nds...)
+
+	var txs []*Tx
+	for _, nd := range nds {
+		if tx, ok := nd.(*Tx); ok {
+			txs = append(txs, tx)
+		}
+	}
+
+	for i, tx := range txs {
+		proof, err := NewMerkleProof(txs, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := proof.Verify(blk); err != nil {
+			t.Fatalf("proof for tx %d: %s", i, err)
+		}
+
+		walked, err := ProveTx(context.Background(), ng, blk, tx.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(walked.RawData(), proof.RawData()) {
+			t.Fatalf("proof for tx %d from the dag differs from the one built from the tx list", i)
+		}
+
+		decoded, err := DecodeMerkleProof(proof.RawData())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !decoded.Cid().Equals(proof.Cid()) || decoded.Index != uint32(i) {
+			t.Fatal("proof did not round trip")
+		}
+	}
+
+	ctx := context.Background()
+	header := *blk
+	header.TxCount = 0
+
+	// proving needs only the TxTree nodes, and the coinbase to find the
+	// depth of the tree when the transaction count is unknown
+	trees := testNodeGetter{}
+	for _, nd := range nds {
+		if _, ok := nd.(*TxTree); ok {
+			trees.add(nd)
+		}
+	}
+	if _, err := ProveTx(ctx, trees, blk, txs[4].Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ProveTx(ctx, trees, &header, txs[4].Cid()); err == nil {
+		t.Fatal("finding the depth of the tree should need the coinbase")
+	}
+	trees.add(txs[0])
+	if _, err := ProveTx(ctx, trees, &header, txs[4].Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ProveTx(ctx, ng, blk, nds[len(nds)-3].Cid()); err == nil {
+		t.Fatal("a TxTree node should not be proven as a transaction")
+	}
+
+	single := &Block{MerkleRoot: txs[0].Cid()}
+	walked, err := ProveTx(ctx, ng, single, txs[0].Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if walked.Index != 0 || len(walked.Siblings) != 0 || walked.Verify(single) != nil {
+		t.Fatal("the only transaction of a block should have an empty proof")
+	}
+
+	// with six transactions, index 6 is the copy of the last pair that pads
+	// the middle layer, and gives the same root as index 4
+	padded, _ := NewMerkleProof(txs, 4)
+	padded.Index = 6
+	if err := padded.Verify(blk); err == nil {
+		t.Fatal("a proof for a padding position should not verify")
+	}
+	if err := padded.Verify(&header); err == nil {
+		t.Fatal("a proof for a padding position should not verify without the transaction count")
+	}
+
+	proof, _ := NewMerkleProof(txs, 3)
+
+	// index 3 in a one byte argument instead of the head itself
+	wide := bytes.Replace(proof.RawData(), []byte("index\x03"), []byte("index\x18\x03"), 1)
+	if _, err := DecodeMerkleProof(wide); err == nil {
+		t.Fatal("non-minimal cbor should be rejected")
+	}
+
+	proof.Index = 2
+	if err := proof.Verify(blk); err == nil {
+		t.Fatal("proof with the wrong index should not verify")
+	}
+
+	lnk, rest, err := proof.ResolveLink([]string{"tx", "outputs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lnk.Cid.Equals(txs[3].Cid()) || len(rest) != 1 {
+		t.Fatal("proof should link to its transaction")
+	}
+}
+
+func TestMerkleBlock(t *testing.T) {
+	blk, nds, _, err := loadTestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var txs []*Tx
+	for _, nd := range nds {
+		if tx, ok := nd.(*Tx); ok {
+			txs = append(txs, tx)
+		}
+	}
+
+	for _, match := range [][]int{{}, {0}, {2, 5}, {0, 1, 2, 3, 4, 5}} {
+		var cids []cid.Cid
+		for _, i := range match {
+			cids = append(cids, txs[i].Cid())
+		}
+
+		mb, err := NewMerkleBlock(blk, txs, cids)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := DecodeMerkleBlock(EncodeMerkleBlock(mb))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found, indices, err := decoded.Verify()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(found) != len(match) {
+			t.Fatalf("expected %d matches, got %d", len(match), len(found))
+		}
+
+		for j, i := range match {
+			if !found[j].Equals(txs[i].Cid()) || indices[j] != uint32(i) {
+				t.Fatalf("match %d is wrong", j)
+			}
+		}
+	}
+
+	mb, _ := NewMerkleBlock(blk, txs, []cid.Cid{txs[2].Cid()})
+	msg := EncodeMerkleBlock(mb)
+	_, err = DecodeMerkleBlock(append(msg, 0))
+	if de, ok := err.(*DecodeError); !ok || de.Err != ErrTrailingData || de.Offset != int64(len(msg)) {
+		t.Fatalf("expected trailing data at %d, got %v", len(msg), err)
+	}
+
+	mb.Tree.Hashes[0] = fill(32, 1)
+	if _, _, err := mb.Verify(); err == nil {
+		t.Fatal("tampered partial merkle tree should not verify")
+	}
+}
+
+func TestStreamingDecoder(t *testing.T) {
+	want, nds, data, err := loadTestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two blocks framed as in a blk*.dat file, behind a reader without
+	// ReadByte
+	frame := []byte{0xfa, 0x1a, 0xf9, 0xbf, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(frame[4:], uint32(len(data)))
+	file := bytes.Join([][]byte{frame, data, frame, data}, nil)
+
+	d := NewDecoder(io.MultiReader(bytes.NewReader(file)))
+	for i := 0; i < 2; i++ {
+		base := int64(i * (len(frame) + len(data)))
+
+		magic, size, err := d.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if magic != 0xfa1af9bf || int(size) != len(data) {
+			t.Fatalf("bad frame %08x %d", magic, size)
+		}
+
+		blk, off, err := d.ReadHeader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if off != base+8 {
+			t.Fatalf("header at offset %d, expected %d", off, base+8)
+		}
+		if !blk.Cid().Equals(want.Cid()) || d.TxCount() != 6 {
+			t.Fatal("decoded the wrong header")
+		}
+
+		if _, _, err := d.ReadFrame(); err == nil {
+			t.Fatal("should not read the next block before the transactions")
+		}
+
+		for j := 0; ; j++ {
+			tx, off, err := d.ReadTx()
+			if err == io.EOF {
+				if j != 6 {
+					t.Fatalf("read %d transactions, expected 6", j)
+				}
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !tx.Cid().Equals(nds[j].Cid()) {
+				t.Fatalf("transaction %d decoded wrong", j)
+			}
+
+			raw := tx.RawData()
+			if !bytes.Equal(file[off:off+int64(len(raw))], raw) {
+				t.Fatalf("transaction %d not found at offset %d", j, off)
+			}
+		}
+
+		if blk.Height != 24202 {
+			t.Fatalf("expected height 24202, got %d", blk.Height)
+		}
+	}
+
+	if d.Offset() != int64(len(file)) {
+		t.Fatalf("consumed %d bytes of %d", d.Offset(), len(file))
+	}
+
+	if _, _, err := d.ReadFrame(); err != io.EOF {
+		t.Fatalf("expected io.EOF at the end of the file, got %v", err)
+	}
+
+	d = NewDecoder(bytes.NewReader(data[:len(data)-10]))
+	if _, _, err := d.ReadHeader(); err != nil {
+		t.Fatal(err)
+	}
+	for err == nil {
+		_, _, err = d.ReadTx()
+	}
+	if de, ok := err.(*DecodeError); !ok || de.Err != ErrTruncated {
+		t.Fatalf("expected ErrTruncated for a truncated block, got %v", err)
+	}
+}
+
+func TestHardenedDecoding(t *testing.T) {
+	_, nds, data, err := loadTestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := nds[1].RawData()
+
+	// a v1 transaction with one input, up to the script length
+	input := append([]byte{1, 0, 0, 0, 1}, make([]byte, 36)...)
+
+	cases := []struct {
+		name   string
+		data   []byte
+		opts   DecodeOptions
+		offset int64
+		field  string
+		err    error
+	}{
+		{"huge script", append(append([]byte{}, input...), 0xff, 0, 0, 0, 0, 0, 0, 0, 1), DecodeOptions{}, 41, "inputs/script", ErrOversize},
+		{"huge input count", []byte{1, 0, 0, 0, 0xfe, 0, 0, 0, 1}, DecodeOptions{}, 4, "inputs", ErrOversize},
+		{"non-canonical count", []byte{1, 0, 0, 0, 0xfd, 1, 0}, DecodeOptions{}, 4, "inputs", ErrNonCanonicalVarint},
+		{"truncated script", append(append([]byte{}, input...), 10, 1, 2), DecodeOptions{}, 42, "inputs/script", ErrTruncated},
+		{"truncated lock time", raw[:len(raw)-2], DecodeOptions{}, int64(len(raw) - 4), "lockTime", ErrTruncated},
+		{"over size limit", raw, DecodeOptions{MaxTxSize: len(raw) - 1}, int64(len(raw) - 4), "lockTime", ErrOversize},
+	}
+
+	for _, c := range cases {
+		_, err := DecodeTxWithOptions(c.data, c.opts)
+		de, ok := err.(*DecodeError)
+		if !ok {
+			t.Fatalf("%s: expected a DecodeError, got %v", c.name, err)
+		}
+
+		if de.Offset != c.offset || de.Field != c.field || de.Err != c.err {
+			t.Fatalf("%s: unexpected error %v", c.name, de)
+		}
+	}
+
+	if _, err := DecodeTxWithOptions(raw, DecodeOptions{MaxTxSize: len(raw)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the transaction count follows the 1344 byte solution
+	hostile := append([]byte{}, data[:1487]...)
+	hostile = append(hostile, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	_, err = DecodeBlockMessage(hostile)
+	if de, ok := err.(*DecodeError); !ok || de.Field != "txCount" || de.Err != ErrOversize {
+		t.Fatalf("expected ErrOversize for the transaction count, got %v", err)
+	}
+
+	_, err = DecodeBlockMessageWithOptions(data, DecodeOptions{MaxBlockSize: len(data) - 1})
+	if de, ok := err.(*DecodeError); !ok || de.Err != ErrOversize {
+		t.Fatalf("expected ErrOversize for a block over the size limit, got %v", err)
+	}
+
+	// a count read past the limit must not wrap the remaining size around
+	r := newReader(bytes.NewReader([]byte{0xff, 0, 0, 0, 0, 1, 0, 0, 0}))
+	r.limitTo(1)
+	if _, err := r.count("count", 1); err == nil {
+		t.Fatal("a count read past the limit should be rejected")
+	}
+
+	// the test block predates Sapling, when transactions were limited to
+	// 100000 bytes
+	blk, nds, _, err := loadTestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := &Tx{
+		Version: 1,
+		Inputs:  nds[1].(*Tx).Inputs,
+		Outputs: []*TxOut{{Value: 1, Script: make([]byte, MaxTxSizeBeforeSapling)}},
+	}
+	msg := blk.header()
+	msg = append(msg, 2)
+	msg = append(msg, nds[0].RawData()...)
+	msg = append(msg, large.RawData()...)
+
+	_, err = DecodeBlockMessage(msg)
+	if de, ok := err.(*DecodeError); !ok || de.Err != ErrOversize {
+		t.Fatalf("expected ErrOversize for a large pre-sapling transaction, got %v", err)
+	}
+
+	if _, err := DecodeBlockMessageWithOptions(msg, DecodeOptions{MaxTxSize: MaxTxSize}); err != nil {
+		t.Fatal(err)
+	}
+
+	if MainNetParams.maxTxSize(MainNetParams.SaplingHeight) != MaxTxSize {
+		t.Fatal("sapling transactions are only limited by the block size")
+	}
+}
+
+func TestVarintRoundTrip(t *testing.T) {
+	for _, n := range []uint64{0, 0xfc, 0xfd, 0xffff, 0x10000, 0xffffffff, 0x100000000, 1<<64 - 1} {
+		buf := new(bytes.Buffer)
+		writeVarInt(buf, n)
+
+		enc := buf.Bytes()
+		out, err := readVarint(bytes.NewReader(enc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != n {
+			t.Fatalf("wrote %d, read back %d", n, out)
+		}
+
+		// the same value in the next wider encoding
+		var wide []byte
+		switch len(enc) {
+		case 1:
+			wide = []byte{0xfd, enc[0], 0}
+		case 3:
+			wide = append([]byte{0xfe}, enc[1], enc[2], 0, 0)
+		case 5:
+			wide = append(append([]byte{0xff}, enc[1:]...), 0, 0, 0, 0)
+		default:
+			continue
+		}
+
+		if _, err := readVarint(bytes.NewReader(wide)); err != ErrNonCanonicalVarint {
+			t.Fatalf("expected %x to be rejected, got %v", wide, err)
+		}
+	}
+
+	if _, err := readVarint(bytes.NewReader([]byte{0xfe, 1, 2})); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF for a short varint, got %v", err)
+	}
+}
+
+func TestStrictDecoding(t *testing.T) {
+	_, nds, data, err := loadTestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strict := DecodeOptions{Strict: true}
+	if _, err := DecodeBlockMessageWithOptions(data, strict); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := nds[3].RawData()
+	for _, nd := range nds[:6] {
+		if _, err := DecodeTxWithOptions(nd.RawData(), strict); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expectErr := func(err, expected error) {
+		t.Helper()
+		if de, ok := err.(*DecodeError); !ok || de.Err != expected {
+			t.Fatalf("expected %v, got %v", expected, err)
+		}
+	}
+
+	trailing := append(append([]byte{}, raw...), 0)
+	if _, err := DecodeTx(trailing); err != nil {
+		t.Fatal(err)
+	}
+	_, err = DecodeTxWithOptions(trailing, strict)
+	expectErr(err, ErrTrailingData)
+
+	_, err = DecodeBlockMessageWithOptions(append(append([]byte{}, data...), 0), strict)
+	expectErr(err, ErrTrailingData)
+
+	_, err = DecodeBlockWithOptions(data, strict)
+	expectErr(err, ErrTrailingData)
+
+	if _, err := DecodeBlockWithOptions(data[:1487], strict); err != nil {
+		t.Fatal(err)
+	}
+
+	// every decodable transaction re-encodes to its input, so check that
+	// the comparison reports the first differing byte
+	r := newReader(bytes.NewReader(raw))
+	r.record(true)
+	if _, err := r.buf("tx", len(raw)); err != nil {
+		t.Fatal(err)
+	}
+	err = r.checkRecorded(0, "tx", append(append([]byte{}, raw[:7]...), raw[7]^1))
+	expectErr(err, ErrNonCanonical)
+	if err.(*DecodeError).Offset != 7 {
+		t.Fatal("non-canonical encoding should be reported at the first differing byte")
+	}
+
+	d := NewDecoderWithOptions(bytes.NewReader(data), strict)
+	if _, _, err := d.ReadHeader(); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, _, err := d.ReadTx()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEncodeBlockMessage(t *testing.T) {
+	blk, nds, data, err := loadTestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var txs []*Tx
+	for _, nd := range nds[:6] {
+		txs = append(txs, nd.(*Tx))
+	}
+
+	out, err := EncodeBlockMessage(blk, txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out, data) {
+		t.Fatal("encoded block message does not match the original")
+	}
+
+	txs[1], txs[2] = txs[2], txs[1]
+	if _, err := EncodeBlockMessage(blk, txs); err == nil {
+		t.Fatal("should not encode transactions out of order")
+	}
+
+	if _, err := EncodeBlockMessage(blk, txs[:5]); err == nil {
+		t.Fatal("should not encode a block with a missing transaction")
+	}
+}
+
+func TestFetchBlock(t *testing.T) {
+	blk, nds, data, err := loadTestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ng := testNodeGetter{}
+	ng.add(blk)
+	ng.add(nds...)
+
+	ctx := context.Background()
+	out, err := FetchBlockMessage(ctx, ng, blk.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out, data) {
+		t.Fatal("fetched block message does not match the original")
+	}
+
+	_, txs, err := FetchBlock(ctx, ng, blk.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tx := range txs {
+		if !tx.Cid().Equals(nds[i].Cid()) {
+			t.Fatalf("transaction %d is out of order", i)
+		}
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := FetchTransactions(cctx, ng, blk); err == nil {
+		t.Fatal("fetching should fail with a cancelled context")
+	}
+
+	delete(ng, nds[4].Cid().KeyString())
+	if _, err := FetchTransactions(ctx, ng, blk); err == nil {
+		t.Fatal("fetching should fail with a missing transaction")
+	}
+}
+
+func TestTxsPath(t *testing.T) {
+	blk, nds, _, err := loadTestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if blk.TxCount != 6 {
+		t.Fatalf("expected a transaction count of 6, got %d", blk.TxCount)
+	}
+
+	ng := testNodeGetter{}
+	ng.add(nds...)
+
+	for i := 0; i < 6; i++ {
+		var cur node.Node = blk
+		path := []string{"txs", strconv.Itoa(i), "version"}
+		for {
+			lnk, rest, err := cur.ResolveLink(path)
+			if err != nil {
+				break
+			}
+
+			cur, err = ng.Get(context.Background(), lnk.Cid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path = rest
+		}
+
+		if !cur.Cid().Equals(nds[i].Cid()) {
+			t.Fatalf("txs/%d resolved to the wrong transaction", i)
+		}
+
+		val, _, err := cur.Resolve(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if val != nds[i].(*Tx).Version {
+			t.Fatalf("txs/%d/version resolved to %v", i, val)
+		}
+	}
+
+	if _, _, err := blk.Resolve([]string{"txs", "6"}); err == nil {
+		t.Fatal("txs/6 should be out of range")
+	}
+
+	single := *blk
+	single.TxCount = 1
+	lnk, rest, err := single.ResolveLink([]string{"txs", "0", "version"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lnk.Cid.Equals(blk.MerkleRoot) || len(rest) != 1 {
+		t.Fatal("the only transaction of a block should be its merkle root")
+	}
+
+	// a block from a blockstore is decoded from its header alone, and its
+	// TxTree nodes don't know their depth, so only a Resolver can count
+	nd, err := DecodeNode(&rawBlock{data: blk.RawData(), cid: blk.Cid()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := nd.(*Block)
+	for _, p := range [][]string{{"txs", "0"}, {"txCount"}} {
+		if _, _, err := header.Resolve(p); err != ErrUnknownTxCount {
+			t.Fatalf("expected ErrUnknownTxCount resolving %v, got %v", p, err)
+		}
+	}
+
+	// a Resolver counts the transactions from the TxTree, also when the
+	// last layer has an odd count
+	five := []node.Node{nds[0], nds[1], nds[2], nds[3], nds[4]}
+	trees, err := mkMerkleTree(five)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial := &Block{Parent: blk.Parent, MerkleRoot: trees[len(trees)-1].Cid()}
+
+	ng.add(header, partial)
+	for _, tree := range trees {
+		ng.add(tree)
+	}
+
+	r := NewResolver(ng)
+	for _, c := range []struct {
+		blk *Block
+		txs []node.Node
+	}{{header, nds[:6]}, {partial, five}} {
+		for i, tx := range c.txs {
+			val, _, err := r.Resolve(context.Background(), c.blk.Cid(), "txs/"+strconv.Itoa(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lnk, ok := val.(*node.Link); !ok || !lnk.Cid.Equals(tx.Cid()) {
+				t.Fatalf("txs/%d resolved to the wrong transaction", i)
+			}
+		}
+
+		if _, _, err := r.Resolve(context.Background(), c.blk.Cid(), "txs/"+strconv.Itoa(len(c.txs))); err == nil {
+			t.Fatalf("txs/%d should be out of range", len(c.txs))
+		}
+
+		count, _, err := r.Resolve(context.Background(), c.blk.Cid(), "txCount")
+		if err != nil || count != uint32(len(c.txs)) {
+			t.Fatalf("txCount resolved to %v, %v", count, err)
+		}
+	}
+}
+
+func TestResolver(t *testing.T) {
+	blk, nds, _, err := loadTestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child := *blk
+	child.Parent = blk.Cid()
+
+	ng := testNodeGetter{}
+	ng.add(&child, blk)
+	ng.add(nds...)
+
+	ctx := context.Background()
+	r := NewResolver(ng)
+
+	for _, path := range []string{"parent/tx/0/1/0/version", "/parent/txs/2/version"} {
+		val, visited, err := r.Resolve(ctx, child.Cid(), path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if val != nds[2].(*Tx).Version {
+			t.Fatalf("%s resolved to %v", path, val)
+		}
+
+		root := nds[len(nds)-1]
+		left := nds[len(nds)-3]
+		leftRight := nds[7]
+		expected := []cid.Cid{child.Cid(), blk.Cid(), root.Cid(), left.Cid(), leftRight.Cid(), nds[2].Cid()}
+		if len(visited) != len(expected) {
+			t.Fatalf("%s visited %d nodes, expected %d", path, len(visited), len(expected))
+		}
+		for i, c := range expected {
+			if !visited[i].Equals(c) {
+				t.Fatalf("%s visited %s at step %d, expected %s", path, visited[i], i, c)
+			}
+		}
+	}
+
+	val, visited, err := r.Resolve(ctx, child.Cid(), "parent/parent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lnk, ok := val.(*node.Link); !ok || !lnk.Cid.Equals(blk.Parent) || len(visited) != 2 {
+		t.Fatal("a path ending at a link should return the link")
+	}
+
+	val, _, err = r.Resolve(ctx, blk.Cid(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nd, ok := val.(node.Node); !ok || !nd.Cid().Equals(blk.Cid()) {
+		t.Fatal("an empty path should resolve to the root node")
+	}
+
+	if _, _, err := r.Resolve(ctx, child.Cid(), "parent/parent/version"); err == nil {
+		t.Fatal("resolving through a missing block should fail")
+	}
+
+	ng[nds[2].Cid().KeyString()] = nds[3]
+	if _, _, err := r.Resolve(ctx, blk.Cid(), "txs/2/version"); err == nil {
+		t.Fatal("a node that doesn't match its cid should be rejected")
+	}
+}
+
+func TestPrevOutPath(t *testing.T) {
+	_, nds, _, err := loadTestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coinbase := nds[0].(*Tx)
+	index := uint32(len(coinbase.Outputs) - 1)
+	spend := &Tx{
+		Version: 1,
+		Inputs: []*TxIn{{
+			PrevTx:      coinbase.Cid(),
+			PrevTxIndex: index,
+			Script:      []byte{0x51},
+			SeqNo:       0xffffffff,
+		}},
+		Outputs: []*TxOut{{Value: 1, Script: []byte{0x51}}},
+	}
+
+	lnk, rest, err := spend.ResolveLink([]string{"inputs", "0", "prevOut", "value"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lnk.Cid.Equals(coinbase.Cid()) || strings.Join(rest, "/") != "outputs/"+strconv.Itoa(int(index))+"/value" {
+		t.Fatalf("prevOut resolved to %s with %v remaining", lnk.Cid, rest)
+	}
+
+	ng := testNodeGetter{}
+	ng.add(spend, coinbase)
+
+	val, visited, err := NewResolver(ng).Resolve(context.Background(), spend.Cid(), "inputs/0/prevOut/value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != coinbase.Outputs[index].Value || len(visited) != 2 {
+		t.Fatalf("inputs/0/prevOut/value resolved to %v", val)
+	}
+
+	if val, _, _ := spend.Resolve([]string{"inputs", "0", "prevTxIndex"}); val != index {
+		t.Fatalf("prevTxIndex resolved to %v", val)
+	}
+
+	if _, _, err := coinbase.Resolve([]string{"inputs", "0", "prevOut"}); err == nil {
+		t.Fatal("a coinbase input spends no output")
+	}
+}
+
+func TestTxLookup(t *testing.T) {
+	_, nds, _, err := loadTestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coinbase := nds[0].(*Tx)
+	v5 := testV5Tx()
+	spend := &Tx{
+		Version: 1,
+		Inputs: []*TxIn{{
+			PrevTx:      v5.TxidCid(),
+			PrevTxIndex: 0,
+			Script:      []byte{0x51},
+			SeqNo:       0xffffffff,
+		}},
+		Outputs: []*TxOut{{Value: 1, Script: []byte{0x51}}},
+	}
+
+	trees, err := mkMerkleTree([]node.Node{coinbase, v5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk := &Block{MerkleRoot: trees[0].Cid()}
+
+	ng := testNodeGetter{}
+	ng.add(spend, coinbase, v5, trees[0])
+
+	ctx := context.Background()
+	val, visited, err := NewResolver(ng).Resolve(ctx, spend.Cid(), "inputs/0/prevOut/value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != v5.Outputs[0].Value || len(visited) != 2 || !visited[1].Equals(v5.Cid()) {
+		t.Fatalf("inputs/0/prevOut/value resolved to %v through %v", val, visited)
+	}
+
+	txs, err := FetchTransactions(ctx, ng, blk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 2 || !txs[1].Cid().Equals(v5.Cid()) {
+		t.Fatal("fetching should find the v5 transaction by its txid")
+	}
+
+	proof, err := NewMerkleProof(txs, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := proof.Verify(blk); err != nil {
+		t.Fatal(err)
+	}
+
+	// a v5 transaction can be proven by either of its CIDs
+	for _, c := range []cid.Cid{v5.Cid(), v5.TxidCid()} {
+		proof, err := ProveTx(ctx, ng, blk, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proof.Tx.Equals(v5.TxidCid()) || proof.Index != 1 {
+			t.Fatalf("proving %s gave a proof of %s at %d", c, proof.Tx, proof.Index)
+		}
+		if err := proof.Verify(blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// only the v5 transaction missing from the GetMany is fetched again
+	cg := &countingGetter{testNodeGetter: ng}
+	mixed := append([]node.Node{coinbase, v5}, nds[1:6]...)
+	many, err := mkMerkleTree(mixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ng.add(mixed...)
+	for _, tree := range many {
+		ng.add(tree)
+	}
+	if _, err := FetchTransactions(ctx, cg, &Block{MerkleRoot: many[len(many)-1].Cid()}); err != nil {
+		t.Fatal(err)
+	}
+	if cg.gets != 2 {
+		t.Fatalf("expected the v5 transaction to be fetched by txid and by cid, got %d fetches", cg.gets)
+	}
+
+	// without a lookup the v5 transaction can't be found from its txid
+	plain := struct{ node.NodeGetter }{ng}
+	if _, _, err := NewResolver(plain).Resolve(ctx, spend.Cid(), "inputs/0/prevOut/value"); err != node.ErrNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if _, err := FetchTransactions(ctx, plain, blk); err == nil {
+		t.Fatal("fetching should fail without a txid lookup")
+	}
+}
+
+// testDAGService stores nodes under their CIDs and can't look up txids.
+type testDAGService map[string]node.Node
+
+var _ node.DAGService = testDAGService{}
+
+func (ds testDAGService) Get(ctx context.Context, c cid.Cid) (node.Node, error) {
+	return testNodeGetter(ds).Get(ctx, c)
+}
+
+func (ds testDAGService) GetMany(ctx context.Context, cids []cid.Cid) <-chan *node.NodeOption {
+	return testNodeGetter(ds).GetMany(ctx, cids)
+}
+
+func (ds testDAGService) Add(ctx context.Context, nd node.Node) error {
+	ds[nd.Cid().KeyString()] = nd
+	return nil
+}
+
+func (ds testDAGService) AddMany(ctx context.Context, nds []node.Node) error {
+	for _, nd := range nds {
+		ds.Add(ctx, nd)
+	}
+	return nil
+}
+
+func (ds testDAGService) Remove(ctx context.Context, c cid.Cid) error {
+	delete(ds, c.KeyString())
+	return nil
+}
+
+func (ds testDAGService) RemoveMany(ctx context.Context, cids []cid.Cid) error {
+	for _, c := range cids {
+		ds.Remove(ctx, c)
+	}
+	return nil
+}
+
+func TestV5BlockThroughDAGService(t *testing.T) {
+	first := testV5Tx()
+	second := testV5Tx()
+	second.LockTime++
+
+	trees, err := mkMerkleTree([]node.Node{first, second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk := &Block{
+		Parent:     hashToCid(fill(32, 1), cid.ZcashBlock),
+		MerkleRoot: trees[0].Cid(),
+		TxCount:    2,
+	}
+
+	ctx := context.Background()
+	ds := testDAGService{}
+	if err := ds.AddMany(ctx, []node.Node{blk, trees[0], first, second}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the TxTree links to the transactions by txid, and a path ending there
+	// doesn't fetch them
+	r := NewResolver(ds)
+	for i, tx := range []*Tx{first, second} {
+		val, visited, err := r.Resolve(ctx, blk.Cid(), "txs/"+strconv.Itoa(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lnk, ok := val.(*node.Link); !ok || !lnk.Cid.Equals(tx.TxidCid()) || len(visited) != 2 {
+			t.Fatalf("txs/%d resolved to %v through %v", i, val, visited)
+		}
+	}
+
+	// going into a v5 transaction needs its Cid, which only a TxLookup gives
+	if _, _, err := r.Resolve(ctx, blk.Cid(), "txs/0/version"); err != node.ErrNotFound {
+		t.Fatalf("expected not found without a txid lookup, got %v", err)
+	}
+
+	val, visited, err := NewResolver(testNodeGetter(ds)).Resolve(ctx, blk.Cid(), "txs/0/version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != first.Version || !visited[2].Equals(first.Cid()) {
+		t.Fatalf("txs/0/version resolved to %v through %v", val, visited)
+	}
+}
+
+// rawBlock is a blocks.Block that trusts its CID, as a blockstore does.
+type rawBlock struct {
+	data []byte
+	cid  cid.Cid
+}
+
+func (b *rawBlock) RawData() []byte                  { return b.data }
+func (b *rawBlock) Cid() cid.Cid                     { return b.cid }
+func (b *rawBlock) String() string                   { return b.cid.String() }
+func (b *rawBlock) Loggable() map[string]interface{} { return nil }
+
+func TestRegisteredDecoders(t *testing.T) {
+	blk, nds, _, err := loadTestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v5 := testV5Tx()
+	for _, nd := range append([]node.Node{blk, v5}, nds...) {
+		out, err := node.Decode(&rawBlock{data: nd.RawData(), cid: nd.Cid()})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !out.Cid().Equals(nd.Cid()) {
+			t.Fatalf("decoded %s as %s", nd.Cid(), out.Cid())
+		}
+
+		if _, ok := out.(*TxTree); ok != (len(nd.RawData()) == 64) {
+			t.Fatalf("decoded %s as the wrong node type", nd.Cid())
+		}
+	}
+
+	if _, err := DecodeNode(&rawBlock{data: nds[1].RawData(), cid: nds[2].Cid()}); err == nil {
+		t.Fatal("data that doesn't match its cid should be rejected")
+	}
+
+	// v5 txids are not a hash of the data, so they are checked by decoding
+	tampered := *v5
+	tampered.LockTime++
+	if _, err := DecodeNode(&rawBlock{data: tampered.RawData(), cid: v5.Cid()}); err == nil {
+		t.Fatal("a v5 transaction that doesn't match its txid should be rejected")
+	}
+
+	withTrailing := append(append([]byte{}, blk.RawData()...), 0)
+	if _, err := DecodeNode(&rawBlock{data: withTrailing, cid: blk.Cid()}); err == nil {
+		t.Fatal("trailing data should be rejected")
+	}
+}
+
+// tx64 builds a 64 byte legacy transaction with a single input and output.
+func tx64(version uint32, prevTx cid.Cid) *Tx {
+	tx := &Tx{
+		Version: version,
+		Inputs: []*TxIn{{
+			PrevTx:      prevTx,
+			PrevTxIndex: 1,
+			Script:      []byte{0x51, 0x51},
+			SeqNo:       0xffffffff,
+		}},
+		Outputs: []*TxOut{{Value: 5000, Script: []byte{0x51, 0x51}}},
+	}
+
+	if version == 2 {
+		tx.Outputs[0].Script = []byte{0x51}
+	}
+	return tx
+}
+
+func TestTxNodeDisambiguation(t *testing.T) {
+	_, nds, _, err := loadTestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := nds[0].Cid()
+
+	// one input with a 13 byte script and no outputs fills 64 bytes
+	noOutputs := append([]byte{1, 0, 0, 0, 1}, make([]byte, 36)...)
+	noOutputs = append(noOutputs, 13)
+	noOutputs = append(noOutputs, make([]byte, 13+4)...)
+	noOutputs = append(noOutputs, 0, 0, 0, 0, 0)
+
+	corpus := []struct {
+		name string
+		data []byte
+		tx   bool
+	}{
+		{"v1 transaction", tx64(1, prev).RawData(), true},
+		{"v2 transaction", tx64(2, prev).RawData(), true},
+		{"v1 coinbase", tx64(1, cid.Undef).RawData(), true},
+		{"transaction without outputs", noOutputs, false},
+		{"filler", fill(64, 0xab), false},
+		{"overwintered header", append([]byte{3, 0, 0, 0x80}, fill(60, 1)...), false},
+	}
+
+	for _, nd := range nds[6:] {
+		corpus = append(corpus, struct {
+			name string
+			data []byte
+			tx   bool
+		}{"tx tree " + nd.Cid().String(), nd.RawData(), false})
+	}
+
+	for _, c := range corpus {
+		if len(c.data) != 64 {
+			t.Fatalf("%s: corpus entry is %d bytes", c.name, len(c.data))
+		}
+
+		nd, err := DecodeMaybeTx(c.data)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+
+		if _, ok := nd.(*Tx); ok != c.tx {
+			t.Fatalf("%s: decoded as %T", c.name, nd)
+		}
+
+		// both readings share a cid, so only context can settle it
+		tree, err := DecodeMaybeTxWithOptions(c.data, DecodeOptions{TxNodeKind: TxTreeNode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := tree.(*TxTree); !ok || !tree.Cid().Equals(nd.Cid()) {
+			t.Fatalf("%s: should decode as a TxTree with the same cid when told to", c.name)
+		}
+	}
+
+	if _, err := DecodeMaybeTxWithOptions(fill(64, 0xab), DecodeOptions{TxNodeKind: TxNode}); err == nil {
+		t.Fatal("filler should not decode when a transaction is expected")
+	}
+
+	// a block of two 64 byte transactions, stored by a getter that took
+	// them for TxTree nodes
+	a, b := tx64(1, cid.Undef), tx64(2, prev)
+	root := &TxTree{Left: &node.Link{Cid: a.Cid()}, Right: &node.Link{Cid: b.Cid()}}
+	blk := &Block{Parent: hashToCid(fill(32, 1), cid.ZcashBlock), MerkleRoot: root.Cid(), TxCount: 2}
+
+	ng := testNodeGetter{}
+	for _, tx := range []*Tx{a, b} {
+		misread, _ := DecodeTxTree(tx.RawData())
+		ng.add(misread)
+	}
+	ng.add(root)
+
+	txs, err := FetchTransactions(context.Background(), ng, blk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 2 || !txs[0].Cid().Equals(a.Cid()) || !txs[1].Cid().Equals(b.Cid()) {
+		t.Fatal("fetched the wrong transactions")
+	}
+
+	// the Resolver takes the same hint from the block, and from prevouts
+	spend := tx64(2, b.Cid())
+	spend.Inputs[0].PrevTxIndex = 0
+	ng.add(blk, spend)
+	r := NewResolver(ng)
+	for _, c := range []struct {
+		root cid.Cid
+		path string
+		val  interface{}
+	}{
+		{blk.Cid(), "txs/1/version", uint32(2)},
+		{blk.Cid(), "tx/0/outputs/0/value", a.Outputs[0].Value},
+		{spend.Cid(), "inputs/0/prevOut/value", b.Outputs[0].Value},
+	} {
+		val, _, err := r.Resolve(context.Background(), c.root, c.path)
+		if err != nil {
+			t.Fatalf("%s: %s", c.path, err)
+		}
+		if val != c.val {
+			t.Fatalf("%s resolved to %v", c.path, val)
+		}
+	}
+
+	blk.TxCount = 0
+	if _, err := FetchTransactions(context.Background(), ng, blk); err == nil {
+		t.Fatal("without the transaction count the getter's reading should stand")
+	}
+
+	ng.add(a, b)
+	if _, err := FetchTransactions(context.Background(), ng, blk); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,188 @@
+package ipldzec
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// This file implements the ZIP 244 transaction identifier and authorizing
+// data commitment used for v5 transactions. Older transaction versions are
+// identified by the double sha256 of their serialization instead.
+
+// blake2b256 hashes the concatenation of data with a personalized 32 byte
+// BLAKE2b instance.
+func blake2b256(person []byte, data ...[]byte) []byte {
+	return blake2bPersonal(32, person, data...)
+}
+
+func branchPersonalization(prefix string, branch uint32) []byte {
+	p := make([]byte, 16)
+	copy(p, prefix)
+	binary.LittleEndian.PutUint32(p[12:], branch)
+	return p
+}
+
+// txidDigest computes the ZIP 244 txid_digest of a v5 transaction.
+func (t *Tx) txidDigest() []byte {
+	return blake2b256(branchPersonalization("ZcashTxHash_", t.ConsensusBranchID),
+		t.headerDigest(),
+		t.transparentDigest(),
+		t.saplingDigest(),
+		t.orchardDigest(),
+	)
+}
+
+func (t *Tx) headerDigest() []byte {
+	buf := make([]byte, 20)
+	binary.LittleEndian.PutUint32(buf, t.header())
+	binary.LittleEndian.PutUint32(buf[4:], t.VersionGroupID)
+	binary.LittleEndian.PutUint32(buf[8:], t.ConsensusBranchID)
+	binary.LittleEndian.PutUint32(buf[12:], t.LockTime)
+	binary.LittleEndian.PutUint32(buf[16:], t.ExpiryHeight)
+	return blake2b256([]byte("ZTxIdHeadersHash"), buf)
+}
+
+func (t *Tx) transparentDigest() []byte {
+	if len(t.Inputs) == 0 && len(t.Outputs) == 0 {
+		return blake2b256([]byte("ZTxIdTranspaHash"))
+	}
+
+	prevouts := new(bytes.Buffer)
+	sequences := new(bytes.Buffer)
+	i := make([]byte, 4)
+	for _, inp := range t.Inputs {
+		prevout := make([]byte, 32)
+		if inp.PrevTx.Defined() {
+			copy(prevout, cidToHash(inp.PrevTx))
+		}
+		prevouts.Write(prevout)
+
+		binary.LittleEndian.PutUint32(i, inp.PrevTxIndex)
+		prevouts.Write(i)
+
+		binary.LittleEndian.PutUint32(i, inp.SeqNo)
+		sequences.Write(i)
+	}
+
+	outputs := new(bytes.Buffer)
+	for _, out := range t.Outputs {
+		out.WriteTo(outputs)
+	}
+
+	return blake2b256([]byte("ZTxIdTranspaHash"),
+		blake2b256([]byte("ZTxIdPrevoutHash"), prevouts.Bytes()),
+		blake2b256([]byte("ZTxIdSequencHash"), sequences.Bytes()),
+		blake2b256([]byte("ZTxIdOutputsHash"), outputs.Bytes()),
+	)
+}
+
+func (t *Tx) saplingDigest() []byte {
+	if len(t.ShieldedSpends) == 0 && len(t.ShieldedOutputs) == 0 {
+		return blake2b256([]byte("ZTxIdSaplingHash"))
+	}
+
+	spends := blake2b256([]byte("ZTxIdSSpendsHash"))
+	if len(t.ShieldedSpends) > 0 {
+		compact := new(bytes.Buffer)
+		noncompact := new(bytes.Buffer)
+		for _, sd := range t.ShieldedSpends {
+			compact.Write(sd.Nullifier)
+			writeMany(noncompact, sd.Cv, sd.Anchor, sd.Rk)
+		}
+
+		spends = blake2b256([]byte("ZTxIdSSpendsHash"),
+			blake2b256([]byte("ZTxIdSSpendCHash"), compact.Bytes()),
+			blake2b256([]byte("ZTxIdSSpendNHash"), noncompact.Bytes()),
+		)
+	}
+
+	outputs := blake2b256([]byte("ZTxIdSOutputHash"))
+	if len(t.ShieldedOutputs) > 0 {
+		compact := new(bytes.Buffer)
+		memos := new(bytes.Buffer)
+		noncompact := new(bytes.Buffer)
+		for _, od := range t.ShieldedOutputs {
+			writeMany(compact, od.Cmu, od.EphemeralKey, od.EncCiphertext[:52])
+			memos.Write(od.EncCiphertext[52:564])
+			writeMany(noncompact, od.Cv, od.EncCiphertext[564:], od.OutCiphertext)
+		}
+
+		outputs = blake2b256([]byte("ZTxIdSOutputHash"),
+			blake2b256([]byte("ZTxIdSOutC__Hash"), compact.Bytes()),
+			blake2b256([]byte("ZTxIdSOutM__Hash"), memos.Bytes()),
+			blake2b256([]byte("ZTxIdSOutN__Hash"), noncompact.Bytes()),
+		)
+	}
+
+	vb := make([]byte, 8)
+	binary.LittleEndian.PutUint64(vb, uint64(t.ValueBalance))
+
+	return blake2b256([]byte("ZTxIdSaplingHash"), spends, outputs, vb)
+}
+
+func (t *Tx) orchardDigest() []byte {
+	ob := t.Orchard
+	if ob == nil || len(ob.Actions) == 0 {
+		return blake2b256([]byte("ZTxIdOrchardHash"))
+	}
+
+	compact := new(bytes.Buffer)
+	memos := new(bytes.Buffer)
+	noncompact := new(bytes.Buffer)
+	for _, a := range ob.Actions {
+		writeMany(compact, a.Nullifier, a.Cmx, a.EphemeralKey, a.EncCiphertext[:52])
+		memos.Write(a.EncCiphertext[52:564])
+		writeMany(noncompact, a.Cv, a.Rk, a.EncCiphertext[564:], a.OutCiphertext)
+	}
+
+	vb := make([]byte, 8)
+	binary.LittleEndian.PutUint64(vb, uint64(ob.ValueBalance))
+
+	return blake2b256([]byte("ZTxIdOrchardHash"),
+		blake2b256([]byte("ZTxIdOrcActCHash"), compact.Bytes()),
+		blake2b256([]byte("ZTxIdOrcActMHash"), memos.Bytes()),
+		blake2b256([]byte("ZTxIdOrcActNHash"), noncompact.Bytes()),
+		[]byte{ob.Flags},
+		vb,
+		ob.Anchor,
+	)
+}
+
+// authDigest computes the ZIP 244 auth_digest of a v5 transaction, which
+// commits to the scripts, proofs and signatures left out of the txid.
+func (t *Tx) authDigest() []byte {
+	scripts := new(bytes.Buffer)
+	for _, inp := range t.Inputs {
+		writeVarInt(scripts, uint64(len(inp.Script)))
+		scripts.Write(inp.Script)
+	}
+
+	sapling := new(bytes.Buffer)
+	for _, sd := range t.ShieldedSpends {
+		sapling.Write(sd.Proof)
+	}
+	for _, sd := range t.ShieldedSpends {
+		sapling.Write(sd.SpendAuthSig)
+	}
+	for _, od := range t.ShieldedOutputs {
+		sapling.Write(od.Proof)
+	}
+	if len(t.ShieldedSpends)+len(t.ShieldedOutputs) > 0 {
+		sapling.Write(t.BindingSig)
+	}
+
+	orchard := new(bytes.Buffer)
+	if ob := t.Orchard; ob != nil && len(ob.Actions) > 0 {
+		orchard.Write(ob.Proof)
+		for _, a := range ob.Actions {
+			orchard.Write(a.SpendAuthSig)
+		}
+		orchard.Write(ob.BindingSig)
+	}
+
+	return blake2b256(branchPersonalization("ZTxAuthHash_", t.ConsensusBranchID),
+		blake2b256([]byte("ZTxAuthTransHash"), scripts.Bytes()),
+		blake2b256([]byte("ZTxAuthSapliHash"), sapling.Bytes()),
+		blake2b256([]byte("ZTxAuthOrchaHash"), orchard.Bytes()),
+	)
+}